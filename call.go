@@ -1,7 +1,15 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 )
 
 type Call struct {
@@ -16,16 +24,218 @@ func (c *Call) Run() {
 	defer resp.Body.Close()
 }
 
-func CallUrl(url string, id int64) error {
-	resp, err := http.Get(url)
+func CallUrl(ctx context.Context, url string, id int64) error {
+	_, _, err := (&Callback{}).Do(ctx, url)
+	return err
+}
+
+// DefaultTimeout bounds callbacks that do not set a timeout of their own.
+const DefaultTimeout = 30 * time.Second
+
+// SnippetSize is how much of a response body is kept.
+const SnippetSize = 512
+
+// Callback describes the HTTP request made when a job fires. The zero value
+// is a plain GET.
+type Callback struct {
+	Method  string            `json:",omitempty"`
+	Header  map[string]string `json:",omitempty"`
+	Body    string            `json:",omitempty"`
+	Timeout time.Duration     `json:",omitempty"`
+}
+
+// check reports what is wrong with cb, if anything.
+func (cb *Callback) check() error {
+	if cb.Method != "" && !callMethods[cb.Method] {
+		return errors.New("unsupported method " + cb.Method)
+	}
+	if cb.Timeout < 0 {
+		return errors.New("timeout should be positive")
+	}
+	return nil
+}
+
+// Do calls url as described by cb, giving up when ctx is done. It returns
+// the status of the response and the first SnippetSize bytes of its body, if
+// there was one.
+func (cb *Callback) Do(ctx context.Context, url string) (int, string, error) {
+	method := cb.Method
+	if method == "" {
+		method = "GET"
+	}
+	var body io.Reader
+	if cb.Body != "" {
+		body = strings.NewReader(cb.Body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
 	if err != nil {
-		// handle error
-		return err
+		return 0, "", err
+	}
+	if cb.Body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range cb.Header {
+		req.Header.Set(k, v)
 	}
-	if resp.StatusCode == 200 {
-		//
-		return err
+
+	timeout := cb.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, "", err
 	}
 	defer resp.Body.Close()
-	return nil
+	snippet, err := ioutil.ReadAll(io.LimitReader(resp.Body, SnippetSize))
+	if err == nil {
+		_, err = io.Copy(ioutil.Discard, resp.Body)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		err = &StatusError{resp.StatusCode}
+	}
+	return resp.StatusCode, string(snippet), err
+}
+
+// StatusError reports a callback answered with a status other than 2xx.
+type StatusError struct {
+	Code int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("callback answered %d %s", e.Code, http.StatusText(e.Code))
+}
+
+// CallJob is the Job that performs the callback, or the nsq publish,
+// described by a journal record. Each attempt is journaled, and failed ones
+// are retried as the record's Retry says, through once entries on Cron that
+// go with the job. A callback that fails for good goes to the dead letters.
+type CallJob struct {
+	Bean Bean
+
+	// Where retries are scheduled.
+	Cron *Cron
+
+	// When this is a retry: which attempt it is, the id of the job it is made
+	// for, and how the earlier attempts went.
+	attempt int
+	origin  int64
+	history []Attempt
+
+	// If set, the outcome of the first run is sent here.
+	notify chan error
+}
+
+func (j *CallJob) Run(id int64) {
+	j.RunContext(context.Background(), id)
+}
+
+// RunContext makes the call, giving up when ctx is done. A job deleted while
+// it runs is neither retried nor sent to the dead letters.
+func (j *CallJob) RunContext(ctx context.Context, id int64) {
+	start := time.Now()
+	status, snippet, err := j.call(ctx)
+	now := time.Now()
+	if j.notify != nil {
+		select {
+		case j.notify <- err:
+		default:
+		}
+	}
+
+	attempt, origin := j.attempt, j.origin
+	if attempt == 0 {
+		attempt, origin = 1, id
+	}
+	a := Attempt{Attempt: attempt, Time: now, Status: status}
+	if err != nil {
+		a.Error = err.Error()
+	}
+	recordRun(Execution{
+		Job:     origin,
+		Attempt: attempt,
+		Start:   start,
+		End:     now,
+		Latency: now.Sub(start),
+		Status:  status,
+		Error:   a.Error,
+		Body:    snippet,
+	})
+
+	history := append(j.history[:len(j.history):len(j.history)], a)
+	record := Bean{Id: origin, Time: now, Method: "attempt", Attempt: attempt, Status: a.Status, Error: a.Error}
+	if context.Cause(ctx) == ErrJobDeleted {
+		// Nothing more to do.
+	} else if j.Cron != nil && j.Bean.Retry.retryable(attempt, err) {
+		at := now.Add(j.Bean.Retry.delay(attempt))
+		record.Schedule = at.Format(onceLayout)
+		j.Cron.AddOncejob(at, j.retry(origin, history), WithOrigin(origin))
+	} else if err != nil {
+		deadLetter(origin, j.Bean, history)
+	}
+	journal(record)
+}
+
+// Skip records a run left out because an earlier one is still going on.
+func (j *CallJob) Skip(id int64, err error) {
+	now := time.Now()
+	recordRun(Execution{Job: id, Start: now, End: now, Error: err.Error(), Skipped: true})
+}
+
+// Host is where the job calls out to: the host of its url, or nsqd.
+func (j *CallJob) Host() string {
+	if j.Bean.Nsq != nil {
+		if nsqd != nil {
+			return nsqd.Address
+		}
+		return ""
+	}
+	if u, err := url.Parse(j.Bean.Url); err == nil {
+		return u.Host
+	}
+	return ""
+}
+
+// Timeout is how long a run of the job may take.
+func (j *CallJob) Timeout() time.Duration {
+	if cb := j.Bean.Callback; cb != nil && cb.Timeout > 0 {
+		return cb.Timeout
+	}
+	return DefaultTimeout
+}
+
+// call publishes the messages of the job, or calls its url.
+func (j *CallJob) call(ctx context.Context) (int, string, error) {
+	if j.Bean.Nsq != nil {
+		return publish(ctx, j.Bean.Nsq)
+	}
+	cb := j.Bean.Callback
+	if cb == nil {
+		cb = &Callback{}
+	}
+	return cb.Do(ctx, j.Bean.Url)
+}
+
+// retry returns the Job for the attempt after the given ones.
+func (j *CallJob) retry(origin int64, history []Attempt) *CallJob {
+	return &CallJob{
+		Bean:    j.Bean,
+		Cron:    j.Cron,
+		attempt: len(history) + 1,
+		origin:  origin,
+		history: history,
+	}
+}
+
+// beanJob returns the Job for a journal record, retrying on c.
+func beanJob(c *Cron, b Bean) *CallJob {
+	return &CallJob{Bean: b, Cron: c}
+}
+
+// journal writes b to the data log, if there is one.
+func journal(b Bean) {
+	if databk != nil {
+		databk.WriteBin(b)
+	}
 }
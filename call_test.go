@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCallback(t *testing.T) {
+	var (
+		method, auth, contentType, body string
+	)
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		method, auth, contentType, body = r.Method, r.Header.Get("Authorization"), r.Header.Get("Content-Type"), string(b)
+		if r.URL.Path == "/slow" {
+			time.Sleep(200 * time.Millisecond)
+		}
+	}))
+	defer target.Close()
+
+	Convey("The zero Callback is a plain GET.", t, func() {
+		_, _, err := (&Callback{}).Do(context.Background(), target.URL)
+		So(err, ShouldBeNil)
+		So(method, ShouldEqual, "GET")
+		So(body, ShouldBeEmpty)
+	})
+
+	Convey("A Callback sends its method, headers and body.", t, func() {
+		cb := &Callback{
+			Method: "POST",
+			Header: map[string]string{"Authorization": "Bearer token"},
+			Body:   `{"a":1}`,
+		}
+		_, _, err := cb.Do(context.Background(), target.URL)
+		So(err, ShouldBeNil)
+		So(method, ShouldEqual, "POST")
+		So(auth, ShouldEqual, "Bearer token")
+		So(contentType, ShouldEqual, "application/json")
+		So(body, ShouldEqual, `{"a":1}`)
+
+		cb.Header["Content-Type"] = "text/plain"
+		_, _, err = cb.Do(context.Background(), target.URL)
+		So(err, ShouldBeNil)
+		So(contentType, ShouldEqual, "text/plain")
+	})
+
+	Convey("A Callback gives up after its timeout.", t, func() {
+		cb := &Callback{Timeout: 50 * time.Millisecond}
+		_, _, err := cb.Do(context.Background(), target.URL+"/slow")
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,36 @@
+package main
+
+import "time"
+
+// Clock tells a Cron the time, and wakes it up when its next entry is due.
+// A Cron uses the real clock unless given another one with WithClock, e.g.
+// one that tests move on by hand.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is a timer of a Clock, like a *time.Timer.
+type Timer interface {
+	// C delivers the time once the timer goes off.
+	C() <-chan time.Time
+
+	// Stop keeps the timer from going off, and reports whether it had not
+	// already.
+	Stop() bool
+}
+
+// realClock is the Clock of the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTimer(d time.Duration) Timer { return realTimer{time.NewTimer(d)} }
+
+type realTimer struct {
+	t *time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time { return t.t.C }
+
+func (t realTimer) Stop() bool { return t.t.Stop() }
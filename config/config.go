@@ -14,15 +14,46 @@ import (
 
 const DefaultSystemConfigPath = "etc/job.conf"
 
+const DefaultNsqTopic = "job"
+
+const DefaultNsqChannel = "job"
+
+// The default limits on callbacks in flight, overall and against one host.
+const (
+	DefaultMaxInFlight = 100
+	DefaultMaxPerHost  = 10
+)
+
 type Config struct {
 	SystemPath string
 	First      string `toml:"conf_first" env:"CONF_FIRST"`
+
+	// The HTTP address of the nsqd jobs publish to, and the topic they use
+	// when they do not name one. Publishing is off without an address.
+	NsqdAddress string `toml:"nsqd_address" env:"JOB_NSQD_ADDRESS"`
+	NsqTopic    string `toml:"nsq_topic" env:"JOB_NSQ_TOPIC"`
+
+	// The HTTP addresses of the nsqlookupds, comma separated, and the topic
+	// and channel jobs are read from. Consuming is off without an address.
+	NsqLookupdAddress string `toml:"nsqlookupd_address" env:"JOB_NSQLOOKUPD_ADDRESS"`
+	NsqConsumeTopic   string `toml:"nsq_consume_topic" env:"JOB_NSQ_CONSUME_TOPIC"`
+	NsqChannel        string `toml:"nsq_channel" env:"JOB_NSQ_CHANNEL"`
+
+	// How many jobs run at a time, overall and against one host; jobs
+	// over either limit wait their turn. 0 means no limit.
+	MaxInFlight int `toml:"max_in_flight" env:"JOB_MAX_IN_FLIGHT"`
+	MaxPerHost  int `toml:"max_per_host" env:"JOB_MAX_PER_HOST"`
 }
 
 func New() *Config {
 	c := new(Config)
 	c.SystemPath = DefaultSystemConfigPath
 	c.First = "Test"
+	c.NsqTopic = DefaultNsqTopic
+	c.NsqConsumeTopic = DefaultNsqTopic
+	c.NsqChannel = DefaultNsqChannel
+	c.MaxInFlight = DefaultMaxInFlight
+	c.MaxPerHost = DefaultMaxPerHost
 	return c
 }
 
@@ -30,7 +61,7 @@ func (c *Config) Load(arguments []string) error {
 	var path string
 	f := flag.NewFlagSet("job", -1)
 	f.SetOutput(ioutil.Discard)
-	f.StringVar(&path, "config", "", "path to config file")
+	c.defineFlags(f, &path)
 	f.Parse(arguments)
 	if path != "" {
 		if err := c.LoadFile(path); err != nil {
@@ -91,12 +122,26 @@ func (c *Config) loadEnv(target interface{}) error {
 }
 
 func (c *Config) LoadFlags(arguments []string) error {
+	var path string
 	f := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
 	f.SetOutput(ioutil.Discard)
-
-	f.StringVar(&c.First, "cf", c.First, "(deprecated)")
+	c.defineFlags(f, &path)
 	if err := f.Parse(arguments); err != nil {
 		return err
 	}
 	return nil
 }
+
+// defineFlags defines every command line flag on f, binding -config to path
+// and the others to c.
+func (c *Config) defineFlags(f *flag.FlagSet, path *string) {
+	f.StringVar(path, "config", "", "path to config file")
+	f.StringVar(&c.First, "cf", c.First, "(deprecated)")
+	f.StringVar(&c.NsqdAddress, "nsqd-address", c.NsqdAddress, "HTTP address of nsqd")
+	f.StringVar(&c.NsqTopic, "nsq-topic", c.NsqTopic, "default nsq topic")
+	f.StringVar(&c.NsqLookupdAddress, "nsqlookupd-address", c.NsqLookupdAddress, "HTTP addresses of nsqlookupd, comma separated")
+	f.StringVar(&c.NsqConsumeTopic, "nsq-consume-topic", c.NsqConsumeTopic, "nsq topic jobs are read from")
+	f.StringVar(&c.NsqChannel, "nsq-channel", c.NsqChannel, "nsq channel jobs are read from")
+	f.IntVar(&c.MaxInFlight, "max-in-flight", c.MaxInFlight, "most jobs running at a time, 0 for no limit")
+	f.IntVar(&c.MaxPerHost, "max-per-host", c.MaxPerHost, "most jobs running against one host at a time, 0 for no limit")
+}
@@ -11,6 +11,10 @@ import (
 func TestConfigToml(t *testing.T) {
 	content := `
 		conf_first = "127.0.0.1:4002"
+		nsqd_address = "127.0.0.1:4151"
+		nsqlookupd_address = "127.0.0.1:4161"
+		nsq_channel = "scheduler"
+		max_per_host = 4
 	`
 	c := New()
 	_, err := toml.Decode(content, &c)
@@ -20,6 +24,13 @@ func TestConfigToml(t *testing.T) {
 	})
 	Convey("ShouldEqual", t, func() {
 		So(c.First, ShouldEqual, "127.0.0.1:4002")
+		So(c.NsqdAddress, ShouldEqual, "127.0.0.1:4151")
+		So(c.NsqTopic, ShouldEqual, DefaultNsqTopic)
+		So(c.NsqLookupdAddress, ShouldEqual, "127.0.0.1:4161")
+		So(c.NsqConsumeTopic, ShouldEqual, DefaultNsqTopic)
+		So(c.NsqChannel, ShouldEqual, "scheduler")
+		So(c.MaxInFlight, ShouldEqual, DefaultMaxInFlight)
+		So(c.MaxPerHost, ShouldEqual, 4)
 	})
 }
 
@@ -32,3 +43,15 @@ func TestConfigEnv(t *testing.T) {
 		So(c.First, ShouldEqual, "this.is.test")
 	})
 }
+
+func TestConfigFlags(t *testing.T) {
+	c := New()
+	err := c.LoadFlags([]string{"-nsqd-address", "10.0.0.1:4151", "-nsq-topic", "delayed", "-max-in-flight", "0"})
+
+	Convey("Flags can use", t, func() {
+		So(err, ShouldBeNil)
+		So(c.NsqdAddress, ShouldEqual, "10.0.0.1:4151")
+		So(c.NsqTopic, ShouldEqual, "delayed")
+		So(c.MaxInFlight, ShouldEqual, 0)
+	})
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BadMessage wraps what is wrong with a message that cannot be handled however
+// often it is tried, such as a malformed request. The Consumer finishes such
+// messages instead of requeueing them.
+type BadMessage struct {
+	Err error
+}
+
+func (e *BadMessage) Error() string {
+	return "bad message: " + e.Err.Error()
+}
+
+// Message is a message delivered by nsqd.
+type Message struct {
+	Id        [16]byte
+	Timestamp time.Time
+	Attempts  uint16
+	Body      []byte
+}
+
+// Defaults of the Consumer.
+const (
+	DefaultMaxAttempts  = 5
+	DefaultRequeueDelay = 10 * time.Second
+	DefaultPollInterval = 30 * time.Second
+)
+
+// Consumer reads a channel of an nsq topic from every nsqd the nsqlookupds
+// know to carry it, speaking the TCP protocol of nsqd.
+//
+// Each message is handed to Handler, one at a time per nsqd, and acked or
+// requeued as it returns:
+//
+//   - nil finishes the message;
+//   - a *BadMessage finishes it too, as trying it again would not help;
+//   - any other error requeues it, RequeueDelay times its attempts later,
+//     until it has been tried MaxAttempts times, when it is finished.
+//
+// Messages dropped for either reason are logged.
+type Consumer struct {
+	Topic   string
+	Channel string
+
+	// The HTTP addresses of the nsqlookupds, polled every PollInterval.
+	Lookupd      []string
+	PollInterval time.Duration
+
+	MaxAttempts  uint16
+	RequeueDelay time.Duration
+
+	Handler func(m *Message) error
+
+	mu    sync.Mutex
+	conns map[string]net.Conn
+	stop  chan struct{}
+	wg    sync.WaitGroup
+}
+
+// NewConsumer returns a Consumer of channel on topic, with the default policy.
+func NewConsumer(topic, channel string, lookupd []string, handler func(m *Message) error) *Consumer {
+	return &Consumer{
+		Topic:        topic,
+		Channel:      channel,
+		Lookupd:      lookupd,
+		PollInterval: DefaultPollInterval,
+		MaxAttempts:  DefaultMaxAttempts,
+		RequeueDelay: DefaultRequeueDelay,
+		Handler:      handler,
+	}
+}
+
+// Start looks up the nsqds carrying the topic, now and then every
+// PollInterval, and consumes from those it is not connected to yet.
+func (c *Consumer) Start() error {
+	if !validTopic(c.Topic) {
+		return fmt.Errorf("invalid topic %q", c.Topic)
+	}
+	if !validTopic(c.Channel) {
+		return fmt.Errorf("invalid channel %q", c.Channel)
+	}
+	if len(c.Lookupd) == 0 {
+		return errors.New("no nsqlookupd")
+	}
+	stop := make(chan struct{})
+	c.mu.Lock()
+	c.conns = make(map[string]net.Conn)
+	c.stop = stop
+	c.mu.Unlock()
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		ticker := time.NewTicker(c.PollInterval)
+		defer ticker.Stop()
+		for {
+			c.poll()
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop stops reading messages, waits for those being handled to be acked
+// or requeued, and then closes every connection. Messages nsqd sent but the
+// Consumer did not get to are requeued by nsqd.
+func (c *Consumer) Stop() {
+	c.mu.Lock()
+	if c.stop == nil {
+		c.mu.Unlock()
+		return
+	}
+	close(c.stop)
+	c.stop = nil
+	for _, conn := range c.conns {
+		// Wakes up a connection waiting for a message, and leaves one
+		// handling a message free to answer it.
+		conn.SetReadDeadline(time.Now())
+	}
+	c.mu.Unlock()
+	c.wg.Wait()
+}
+
+// poll connects to the nsqds the nsqlookupds report that it is not connected to.
+func (c *Consumer) poll() {
+	for _, lookupd := range c.Lookupd {
+		addrs, err := c.lookup(lookupd)
+		if err != nil {
+			log.Printf("nsq consumer: lookup %s: %s", lookupd, err)
+			continue
+		}
+		for _, addr := range addrs {
+			c.connect(addr)
+		}
+	}
+}
+
+// lookup returns the TCP addresses of the nsqds lookupd knows to carry the topic.
+func (c *Consumer) lookup(lookupd string) ([]string, error) {
+	if !strings.HasPrefix(lookupd, "http") {
+		lookupd = "http://" + lookupd
+	}
+	client := &http.Client{Timeout: DefaultTimeout}
+	resp, err := client.Get(lookupd + "/lookup?topic=" + c.Topic)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		// No nsqd has the topic yet.
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &StatusError{resp.StatusCode}
+	}
+
+	type producers struct {
+		Producers []struct {
+			BroadcastAddress string `json:"broadcast_address"`
+			TcpPort          int    `json:"tcp_port"`
+		} `json:"producers"`
+	}
+	// Older nsqlookupds wrap their answer in "data".
+	var res struct {
+		producers
+		Data *producers `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, err
+	}
+	if res.Data != nil {
+		res.producers = *res.Data
+	}
+	var addrs []string
+	for _, p := range res.Producers {
+		addrs = append(addrs, net.JoinHostPort(p.BroadcastAddress, strconv.Itoa(p.TcpPort)))
+	}
+	return addrs, nil
+}
+
+// connect subscribes to addr and consumes from it until the connection drops.
+func (c *Consumer) connect(addr string) {
+	c.mu.Lock()
+	_, ok := c.conns[addr]
+	c.mu.Unlock()
+	if ok {
+		return
+	}
+	conn, err := net.DialTimeout("tcp", addr, DefaultTimeout)
+	if err != nil {
+		log.Printf("nsq consumer: %s: %s", addr, err)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stop == nil {
+		conn.Close()
+		return
+	}
+	c.conns[addr] = conn
+	stop := c.stop
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		err := c.consume(conn, stop)
+		c.mu.Lock()
+		delete(c.conns, addr)
+		stopped := c.stop == nil
+		c.mu.Unlock()
+		conn.Close()
+		if err != nil && !stopped {
+			log.Printf("nsq consumer: %s: %s", addr, err)
+		}
+	}()
+}
+
+// The frame types of nsqd.
+const (
+	frameResponse = 0
+	frameError    = 1
+	frameMessage  = 2
+)
+
+// consume subscribes on conn and handles the messages that come in, until
+// stop is closed.
+func (c *Consumer) consume(conn net.Conn, stop <-chan struct{}) error {
+	r := bufio.NewReader(conn)
+	if _, err := fmt.Fprintf(conn, "  V2SUB %s %s\n", c.Topic, c.Channel); err != nil {
+		return err
+	}
+	typ, data, err := readFrame(r)
+	if err != nil {
+		return err
+	}
+	if typ != frameResponse || string(data) != "OK" {
+		return fmt.Errorf("subscribe: %s", data)
+	}
+	if _, err := io.WriteString(conn, "RDY 1\n"); err != nil {
+		return err
+	}
+
+	for {
+		typ, data, err := readFrame(r)
+		if err != nil {
+			return err
+		}
+		switch typ {
+		case frameResponse:
+			if string(data) == "_heartbeat_" {
+				_, err = io.WriteString(conn, "NOP\n")
+			}
+		case frameError:
+			log.Printf("nsq consumer: %s", data)
+		case frameMessage:
+			select {
+			case <-stop:
+				// Left for nsqd to requeue.
+				return nil
+			default:
+			}
+			var m *Message
+			if m, err = decodeMessage(data); err != nil {
+				return err
+			}
+			_, err = io.WriteString(conn, c.handle(m))
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// handle passes m to the Handler and returns the command that acks or
+// requeues it.
+func (c *Consumer) handle(m *Message) string {
+	err := c.Handler(m)
+	if err == nil {
+		return fmt.Sprintf("FIN %s\n", m.Id[:])
+	}
+	if _, ok := err.(*BadMessage); ok || m.Attempts >= c.MaxAttempts {
+		log.Printf("nsq consumer: dropping message %s after %d attempts: %s", m.Id[:], m.Attempts, err)
+		return fmt.Sprintf("FIN %s\n", m.Id[:])
+	}
+	delay := c.RequeueDelay * time.Duration(m.Attempts)
+	return fmt.Sprintf("REQ %s %d\n", m.Id[:], delay/time.Millisecond)
+}
+
+// readFrame reads a frame: its size, its type and then its data.
+func readFrame(r io.Reader) (int32, []byte, error) {
+	var size, typ int32
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return 0, nil, err
+	}
+	if size < 4 {
+		return 0, nil, fmt.Errorf("bad frame size %d", size)
+	}
+	if err := binary.Read(r, binary.BigEndian, &typ); err != nil {
+		return 0, nil, err
+	}
+	data := make([]byte, size-4)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return 0, nil, err
+	}
+	return typ, data, nil
+}
+
+// decodeMessage reads the data of a message frame: a timestamp in
+// nanoseconds, the number of attempts, the id and then the body.
+func decodeMessage(data []byte) (*Message, error) {
+	if len(data) < 26 {
+		return nil, fmt.Errorf("short message of %d bytes", len(data))
+	}
+	m := &Message{
+		Timestamp: time.Unix(0, int64(binary.BigEndian.Uint64(data[:8]))),
+		Attempts:  binary.BigEndian.Uint16(data[8:10]),
+		Body:      data[26:],
+	}
+	copy(m.Id[:], data[10:26])
+	return m, nil
+}
+
+// beanHandler returns the Handler scheduling the Bean-shaped request in each
+// message on c. The request is checked as the add handlers check their
+// forms; only a failed lookup of the host of its url that may go away by
+// itself is retried, the others are *BadMessage.
+func beanHandler(c *Cron) func(m *Message) error {
+	return func(m *Message) error {
+		var bean Bean
+		if err := json.Unmarshal(m.Body, &bean); err != nil {
+			return &BadMessage{err}
+		}
+		if _, _, err := addBean(c, bean); err != nil {
+			if temporary(err) {
+				return err
+			}
+			return &BadMessage{err}
+		}
+		return nil
+	}
+}
+
+// temporary says whether err is a failed DNS lookup that may go away by
+// itself.
+func temporary(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsTemporary
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// tcpNsqd speaks enough of the TCP protocol of nsqd to deliver messages and
+// passes on the commands it gets back.
+type tcpNsqd struct {
+	ln       net.Listener
+	send     chan []byte
+	commands chan string
+}
+
+func newTcpNsqd() *tcpNsqd {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	n := &tcpNsqd{ln: ln, send: make(chan []byte, 10), commands: make(chan string, 10)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go n.serve(conn)
+		}
+	}()
+	return n
+}
+
+func (n *tcpNsqd) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	magic := make([]byte, 4)
+	if _, err := r.Read(magic); err != nil || string(magic) != "  V2" {
+		return
+	}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "SUB "):
+			conn.Write(frame(frameResponse, []byte("OK")))
+		case strings.HasPrefix(line, "RDY "):
+			go func() {
+				for data := range n.send {
+					if _, err := conn.Write(data); err != nil {
+						return
+					}
+				}
+			}()
+		default:
+			n.commands <- line
+		}
+	}
+}
+
+func (n *tcpNsqd) port() int {
+	return n.ln.Addr().(*net.TCPAddr).Port
+}
+
+// message queues a message frame.
+func (n *tcpNsqd) message(id string, attempts uint16, body []byte) {
+	data := make([]byte, 26, 26+len(body))
+	binary.BigEndian.PutUint64(data, uint64(time.Now().UnixNano()))
+	binary.BigEndian.PutUint16(data[8:], attempts)
+	copy(data[10:], id)
+	n.send <- frame(frameMessage, append(data, body...))
+}
+
+func (n *tcpNsqd) command() string {
+	select {
+	case cmd := <-n.commands:
+		return cmd
+	case <-time.After(ONE_SECOND):
+		return "timeout"
+	}
+}
+
+func frame(typ int32, data []byte) []byte {
+	b := make([]byte, 8, 8+len(data))
+	binary.BigEndian.PutUint32(b, uint32(len(data)+4))
+	binary.BigEndian.PutUint32(b[4:], uint32(typ))
+	return append(b, data...)
+}
+
+// fakeLookupd reports nsqd as the only producer of every topic, in the
+// format of older nsqlookupds if wrapped is set.
+func fakeLookupd(nsqd *tcpNsqd, wrapped bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/lookup" {
+			http.NotFound(w, r)
+			return
+		}
+		producers := fmt.Sprintf(`{"channels":[],"producers":[{"broadcast_address":"127.0.0.1","tcp_port":%d}]}`, nsqd.port())
+		if wrapped {
+			producers = `{"status_code":200,"status_txt":"OK","data":` + producers + `}`
+		}
+		w.Write([]byte(producers))
+	}))
+}
+
+func TestConsumer(t *testing.T) {
+	nsqd := newTcpNsqd()
+	defer nsqd.ln.Close()
+	lookupd := fakeLookupd(nsqd, false)
+	defer lookupd.Close()
+
+	// What the handler returns, one for each message.
+	failures := make(chan error, 3)
+	c := NewConsumer("delayed", "job", []string{lookupd.URL}, func(m *Message) error {
+		return <-failures
+	})
+	c.MaxAttempts, c.RequeueDelay = 3, 10*time.Millisecond
+	if err := c.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	Convey("Handled messages are finished.", t, func() {
+		failures <- nil
+		nsqd.message("0000000000000001", 1, []byte("{}"))
+		So(nsqd.command(), ShouldEqual, "FIN 0000000000000001")
+	})
+
+	Convey("Bad messages are finished too.", t, func() {
+		failures <- &BadMessage{errors.New("bad")}
+		nsqd.message("0000000000000002", 1, []byte("{}"))
+		So(nsqd.command(), ShouldEqual, "FIN 0000000000000002")
+	})
+
+	Convey("Other failures are requeued, later each time, until the last attempt.", t, func() {
+		for i := 0; i < 3; i++ {
+			failures <- errors.New("try again")
+		}
+		nsqd.message("0000000000000003", 1, []byte("{}"))
+		So(nsqd.command(), ShouldEqual, "REQ 0000000000000003 10")
+		nsqd.message("0000000000000003", 2, []byte("{}"))
+		So(nsqd.command(), ShouldEqual, "REQ 0000000000000003 20")
+		nsqd.message("0000000000000003", 3, []byte("{}"))
+		So(nsqd.command(), ShouldEqual, "FIN 0000000000000003")
+	})
+
+	Convey("Heartbeats are answered.", t, func() {
+		nsqd.send <- frame(frameResponse, []byte("_heartbeat_"))
+		So(nsqd.command(), ShouldEqual, "NOP")
+	})
+
+	Convey("Older nsqlookupds are understood.", t, func() {
+		old := fakeLookupd(nsqd, true)
+		defer old.Close()
+		addrs, err := c.lookup(old.URL)
+		So(err, ShouldBeNil)
+		So(addrs, ShouldResemble, []string{"127.0.0.1:" + strconv.Itoa(nsqd.port())})
+	})
+
+	Convey("Topics and channels are checked.", t, func() {
+		So(NewConsumer("bad topic", "job", []string{lookupd.URL}, nil).Start(), ShouldNotBeNil)
+		So(NewConsumer("delayed", "", []string{lookupd.URL}, nil).Start(), ShouldNotBeNil)
+		So(NewConsumer("delayed", "job", nil, nil).Start(), ShouldNotBeNil)
+	})
+}
+
+// Stopping lets the message being handled be finished before the
+// connection is closed, so that nsqd does not deliver it again.
+func TestConsumerStop(t *testing.T) {
+	nsqd := newTcpNsqd()
+	defer nsqd.ln.Close()
+	lookupd := fakeLookupd(nsqd, false)
+	defer lookupd.Close()
+
+	handling, release := make(chan bool, 1), make(chan bool)
+	c := NewConsumer("delayed", "job", []string{lookupd.URL}, func(m *Message) error {
+		handling <- true
+		<-release
+		return nil
+	})
+	if err := c.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	Convey("Stop waits for the message being handled and finishes it.", t, func() {
+		nsqd.message("0000000000000001", 1, []byte("{}"))
+		<-handling
+		stopped := make(chan bool)
+		go func() {
+			c.Stop()
+			close(stopped)
+		}()
+		time.Sleep(A_MOMENT)
+		select {
+		case <-stopped:
+			t.Error("Stop returned while a message was being handled")
+		default:
+		}
+		close(release)
+		So(nsqd.command(), ShouldEqual, "FIN 0000000000000001")
+		select {
+		case <-stopped:
+		case <-time.After(ONE_SECOND):
+			t.Error("Stop did not return")
+		}
+	})
+}
+
+func TestBeanHandler(t *testing.T) {
+	defer testMain()()
+
+	nsqd := newTcpNsqd()
+	defer nsqd.ln.Close()
+	lookupd := fakeLookupd(nsqd, false)
+	defer lookupd.Close()
+
+	c := NewConsumer("delayed", "job", []string{lookupd.URL}, beanHandler(MainCron))
+	if err := c.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	send := func(id string, bean interface{}) string {
+		body, ok := bean.([]byte)
+		if !ok {
+			body, _ = json.Marshal(bean)
+		}
+		nsqd.message(id, 1, body)
+		return nsqd.command()
+	}
+
+	Convey("Requests are scheduled on MainCron and journaled.", t, func() {
+		at := time.Now().Add(time.Hour)
+		So(send("0000000000000001", Bean{Method: "once", Url: "127.0.0.1/cb", Schedule: at.Format(time.RFC3339)}), ShouldEqual, "FIN 0000000000000001")
+		So(send("0000000000000002", Bean{Method: "cron", Url: "http://127.0.0.1/cb", Schedule: "0 0 * * * ?"}), ShouldEqual, "FIN 0000000000000002")
+
+		So(len(MainCron.Entries()), ShouldEqual, 2)
+		beans := readJournal(databk.logfile.Name())
+		So(len(beans), ShouldEqual, 2)
+		So(beans[0].Method, ShouldEqual, "once")
+		So(beans[0].Url, ShouldEqual, "http://127.0.0.1/cb")
+		So(beans[1].Method, ShouldEqual, "cron")
+		So(beans[1].Id, ShouldNotEqual, beans[0].Id)
+	})
+
+	Convey("Bad requests are finished without being scheduled.", t, func() {
+		for i, bean := range []interface{}{
+			[]byte("not json"),
+			Bean{Method: "later", Url: "http://127.0.0.1/cb"},
+			Bean{Method: "cron", Url: "http://127.0.0.1/cb", Schedule: "61 * * * * ?"},
+			Bean{Method: "once", Url: "http://127.0.0.1/cb", Schedule: time.Now().Add(-time.Hour).Format(time.RFC3339)},
+			Bean{Method: "now"},
+			Bean{Method: "now", Url: "http://127.0.0.1/cb", Callback: &Callback{Method: "brew"}},
+			Bean{Method: "now", Url: "http://127.0.0.1/cb", Retry: &Retry{Attempts: 3, Jitter: 2}},
+			Bean{Method: "now", Nsq: &Publish{Messages: []string{"m"}}},
+			Bean{Method: "once", Url: "http://127.0.0.1/cb", Delay: "-1h"},
+			Bean{Method: "cron", Url: "http://127.0.0.1/cb", Schedule: "0 0 * * * ?", Tz: "Mars/Base"},
+		} {
+			id := fmt.Sprintf("%016d", i+10)
+			So(send(id, bean), ShouldEqual, "FIN "+id)
+		}
+		So(len(MainCron.Entries()), ShouldEqual, 2)
+		So(len(readJournal(databk.logfile.Name())), ShouldEqual, 2)
+	})
+
+	Convey("Once requests may give a delay or a wall time in a time zone.", t, func() {
+		shanghai, err := time.LoadLocation("Asia/Shanghai")
+		So(err, ShouldBeNil)
+		at := time.Now().Add(2 * time.Hour).In(shanghai).Truncate(time.Second)
+		So(send("0000000000000031", Bean{Method: "once", Url: "http://127.0.0.1/cb", Delay: "1h"}), ShouldEqual, "FIN 0000000000000031")
+		So(send("0000000000000032", Bean{Method: "once", Url: "http://127.0.0.1/cb", Schedule: at.Format("2006-01-02T15:04:05"), Tz: "Asia/Shanghai"}), ShouldEqual, "FIN 0000000000000032")
+
+		beans := readJournal(databk.logfile.Name())
+		So(len(beans), ShouldEqual, 4)
+		delayed, err := time.Parse(onceLayout, beans[2].Schedule)
+		So(err, ShouldBeNil)
+		So(delayed.Sub(beans[2].Time), ShouldBeBetween, time.Hour-time.Second, time.Hour+time.Second)
+		So(beans[2].Delay, ShouldBeEmpty)
+		zoned, err := time.Parse(onceLayout, beans[3].Schedule)
+		So(err, ShouldBeNil)
+		So(zoned.Equal(at), ShouldBeTrue)
+		So(beans[3].Tz, ShouldBeEmpty)
+	})
+}
+
+func TestTemporary(t *testing.T) {
+	Convey("Only failed DNS lookups that may go away are temporary.", t, func() {
+		So(temporary(&AddError{"url", &net.DNSError{Err: "timeout", IsTemporary: true}}), ShouldBeTrue)
+		So(temporary(&AddError{"url", &net.DNSError{Err: "no such host", IsNotFound: true}}), ShouldBeFalse)
+		So(temporary(&AddError{"url", errors.New("missing host")}), ShouldBeFalse)
+	})
+}
@@ -3,22 +3,56 @@
 package main
 
 import (
+	"container/heap"
+	"context"
+	"errors"
 	"sort"
+	"sync"
 	"sync/atomic"
 	"time"
 )
 
 // Cron keeps track of any number of entries, invoking the associated func as
 // specified by the schedule. It may be started, stopped, and the entries may
-// be inspected while running.
+// be inspected while running. It is safe for concurrent use.
+//
+// While running, the entries belong to the run loop, which is handed the
+// changes to them; otherwise they are changed directly, under mu.
 type Cron struct {
-	entries   []*Entry
+	entries   entryHeap
+	index     map[int64]*Entry
+	followers map[int64]map[int64]*Entry
 	stop      chan struct{}
 	add       chan *Entry
-	del       chan int64
+	del       chan jobReq
+	pause     chan jobReq
+	resume    chan jobReq
+	update    chan jobReq
+	lookup    chan jobReq
 	snapshot  chan []*Entry
-	running   bool
 	increment int64
+
+	// mu guards running, and the entries while not running.
+	mu      sync.Mutex
+	running bool
+
+	// The jobs running, and closing, closed once Shutdown is called.
+	jobs     sync.WaitGroup
+	closing  chan struct{}
+	shutdown sync.Once
+
+	// How late an activation may be run before it counts as missed.
+	misfireThreshold time.Duration
+
+	// The context the jobs of the run loop derive theirs from, and the
+	// cancel funcs of the runs going on, by entry id.
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	runsMu sync.Mutex
+	runs   map[int64]*jobRuns
+
+	executor *Executor
+	clock    Clock
 }
 
 // Job is an interface for submitted cron jobs.
@@ -26,6 +60,55 @@ type Job interface {
 	Run(int64)
 }
 
+// ContextJob is a Job that can be told to give up. Cron calls RunContext
+// instead of Run, with a context that is cancelled when the job is deleted,
+// when the Cron is stopped and when the job runs past its Timeout, if it
+// has one.
+type ContextJob interface {
+	Job
+	RunContext(ctx context.Context, id int64)
+}
+
+// TimeoutJob is a Job with a deadline for each of its runs.
+type TimeoutJob interface {
+	Job
+	Timeout() time.Duration
+}
+
+// The causes of the cancellation of a ContextJob, see context.Cause.
+var (
+	ErrJobDeleted  = errors.New("job deleted")
+	ErrCronStopped = errors.New("cron stopped")
+)
+
+// HostJob is a Job that calls out to a host. The Executor limits how many
+// jobs run against the same host at a time.
+type HostJob interface {
+	Job
+	Host() string
+}
+
+// SkipJob is a Job that is told when a run of it is skipped under the
+// Overlap of its entry, with ErrStillRunning or ErrQueueFull.
+type SkipJob interface {
+	Job
+	Skip(id int64, err error)
+}
+
+// jobRun is a run of a job going on, followed by the runs queued behind it.
+type jobRun struct {
+	cancel context.CancelCauseFunc
+}
+
+// jobRuns are the runs of a job going on, and how many more are queued.
+type jobRuns struct {
+	runs   map[*jobRun]struct{}
+	queued int
+
+	// The Origin of the entry the runs are of.
+	origin int64
+}
+
 // The Schedule describes a job's duty cycle.
 type Schedule interface {
 	// Return the next activation time, later than the given time.
@@ -45,6 +128,19 @@ func (s *OnceSchedule) Next(t time.Time) time.Time {
 	return time.Time{}
 }
 
+// nowSchedule activates once, as soon as the scheduler looks at it.
+type nowSchedule struct {
+	fired bool
+}
+
+func (s *nowSchedule) Next(t time.Time) time.Time {
+	if s.fired {
+		return time.Time{}
+	}
+	s.fired = true
+	return t
+}
+
 // Entry consists of a schedule and the func to execute on that schedule.
 type Entry struct {
 	// The schedule on which this job should be run.
@@ -63,6 +159,63 @@ type Entry struct {
 
 	// The Job id
 	Id int64
+
+	// A paused entry stays in the Cron but is not run. Its Next time is zero
+	// until it is resumed.
+	Paused bool
+
+	// What happens when the entry is due while its job is still running.
+	Overlap Overlap
+
+	// What happens to the activations of the entry that were missed.
+	Misfire Misfire
+
+	// The id of the job the entry was scheduled for, such as the job a
+	// retry is made for, or 0. Deleting that job deletes the entry too.
+	Origin int64
+
+	// When the entry was last known to be on time, if it was before the
+	// Cron started, e.g. the last run of a restored job.
+	since time.Time
+
+	// The place of the entry in the entry table.
+	index int
+}
+
+// EntryOption sets up an Entry as it is added or updated.
+type EntryOption func(*Entry)
+
+// WithOverlap sets the Overlap of an entry.
+func WithOverlap(o Overlap) EntryOption {
+	return func(e *Entry) { e.Overlap = o }
+}
+
+// WithMisfire sets the Misfire of an entry.
+func WithMisfire(m Misfire) EntryOption {
+	return func(e *Entry) { e.Misfire = m }
+}
+
+// WithMissedSince has the activations of an entry after t and before the
+// Cron starts count as missed, e.g. since the last run of a restored job.
+func WithMissedSince(t time.Time) EntryOption {
+	return func(e *Entry) { e.since = t }
+}
+
+// WithOrigin has an entry go with the job with the given id, see
+// Entry.Origin.
+func WithOrigin(id int64) EntryOption {
+	return func(e *Entry) { e.Origin = id }
+}
+
+// jobReq asks the run loop to change the entry with the given id, and reports
+// back whether there was one.
+type jobReq struct {
+	id       int64
+	schedule Schedule
+	job      Job
+	opts     []EntryOption
+	entry    *Entry
+	reply    chan bool
 }
 
 // byTime is a wrapper for sorting the entry array by time
@@ -84,17 +237,63 @@ func (s byTime) Less(i, j int) bool {
 	return s[i].Next.Before(s[j].Next)
 }
 
-// New returns a new Cron job runner.
-func New() *Cron {
-	return &Cron{
+// Option sets up a Cron in New.
+type Option func(*Cron)
+
+// WithExecutor has the jobs of the Cron run by e. By default they are run
+// with no limit.
+func WithExecutor(e *Executor) Option {
+	return func(c *Cron) { c.executor = e }
+}
+
+// WithMisfireThreshold sets how late an activation may be run before it
+// counts as missed, DefaultMisfireThreshold by default.
+func WithMisfireThreshold(d time.Duration) Option {
+	return func(c *Cron) { c.misfireThreshold = d }
+}
+
+// WithClock has the Cron tell the time by clock rather than the real clock.
+func WithClock(clock Clock) Option {
+	return func(c *Cron) { c.clock = clock }
+}
+
+// New returns a new Cron job runner, set up by opts.
+func New(opts ...Option) *Cron {
+	c := &Cron{
 		entries:   nil,
+		index:     make(map[int64]*Entry),
+		followers: make(map[int64]map[int64]*Entry),
 		add:       make(chan *Entry),
-		del:       make(chan int64),
+		del:       make(chan jobReq),
+		pause:     make(chan jobReq),
+		resume:    make(chan jobReq),
+		update:    make(chan jobReq),
+		lookup:    make(chan jobReq),
 		stop:      make(chan struct{}),
 		snapshot:  make(chan []*Entry),
+		closing:   make(chan struct{}),
+		runs:      make(map[int64]*jobRuns),
+		executor:  NewExecutor(0, 0),
+		clock:     realClock{},
 		running:   false,
 		increment: time.Now().UnixNano(),
+
+		misfireThreshold: DefaultMisfireThreshold,
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// now returns the time on the clock of the Cron.
+func (c *Cron) now() time.Time {
+	return c.clock.Now().Local()
+}
+
+// Executor returns the Executor running the jobs.
+func (c *Cron) Executor() *Executor {
+	return c.executor
 }
 
 // A wrapper that turns a func() into a cron.Job
@@ -107,16 +306,109 @@ func (c *Cron) AddFunc(spec string, cmd func(id int64)) int64 {
 	return c.AddJob(spec, FuncJob(cmd))
 }
 
-func (c *Cron) DelJob(id int64) {
+// DelJob removes the Job with the given id, and the entries scheduled for it,
+// and cancels their runs going on. It reports whether there was any.
+func (c *Cron) DelJob(id int64) bool {
+	ok := c.request(c.del, jobReq{id: id}, c.delEntry)
+	return c.cancelRuns(id, ErrJobDeleted) || ok
+}
+
+// PauseJob keeps the Job with the given id from running until it is resumed.
+// It reports whether there was one.
+func (c *Cron) PauseJob(id int64) bool {
+	return c.request(c.pause, jobReq{id: id}, c.pauseEntry)
+}
+
+// ResumeJob lets a paused Job run again, from its next activation after now.
+// It reports whether there was one.
+func (c *Cron) ResumeJob(id int64) bool {
+	return c.request(c.resume, jobReq{id: id}, c.resumeEntry)
+}
+
+// UpdateJob swaps the Schedule and the Job of the entry with the given id,
+// keeping its id and Prev time, and applies opts to it. A nil schedule or cmd
+// leaves that part as it is. It reports whether there was such an entry.
+func (c *Cron) UpdateJob(id int64, schedule Schedule, cmd Job, opts ...EntryOption) bool {
+	return c.request(c.update, jobReq{id: id, schedule: schedule, job: cmd, opts: opts}, c.updateEntry)
+}
+
+// Entry returns a copy of the entry with the given id, and whether there was
+// one.
+func (c *Cron) Entry(id int64) (Entry, bool) {
+	var entry Entry
+	ok := c.request(c.lookup, jobReq{id: id, entry: &entry}, c.lookupEntry)
+	return entry, ok
+}
+
+// request hands a change to the run loop, or applies it directly if the
+// scheduler is not running.
+func (c *Cron) request(ch chan jobReq, req jobReq, apply func(jobReq) bool) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	if !c.running {
-		for i, entry := range c.entries {
-			if entry.Id == id {
-				c.entries = append(c.entries[:i], c.entries[i+1:]...)
-				return
-			}
+		return apply(req)
+	}
+	req.reply = make(chan bool, 1)
+	ch <- req
+	return <-req.reply
+}
+
+func (c *Cron) delEntry(req jobReq) bool {
+	entry, ok := c.index[req.id]
+	if ok {
+		c.removeEntry(entry)
+	}
+	for _, follower := range c.followers[req.id] {
+		c.removeEntry(follower)
+		ok = true
+	}
+	return ok
+}
+
+func (c *Cron) lookupEntry(req jobReq) bool {
+	entry, ok := c.index[req.id]
+	if ok {
+		*req.entry = *entry
+	}
+	return ok
+}
+
+func (c *Cron) pauseEntry(req jobReq) bool {
+	entry, ok := c.index[req.id]
+	if ok {
+		entry.Paused = true
+		c.setNext(entry, time.Time{})
+	}
+	return ok
+}
+
+func (c *Cron) resumeEntry(req jobReq) bool {
+	entry, ok := c.index[req.id]
+	if ok && entry.Paused {
+		entry.Paused = false
+		c.setNext(entry, entry.Schedule.Next(c.now()))
+	}
+	return ok
+}
+
+func (c *Cron) updateEntry(req jobReq) bool {
+	entry, ok := c.index[req.id]
+	if !ok {
+		return false
+	}
+	if req.job != nil {
+		entry.Job = req.job
+	}
+	for _, opt := range req.opts {
+		opt(entry)
+	}
+	if req.schedule != nil {
+		entry.Schedule = req.schedule
+		if !entry.Paused {
+			c.setNext(entry, entry.Schedule.Next(c.now()))
 		}
 	}
-	c.del <- id
+	return true
 }
 
 // AddFunc adds a Job to the Cron to be run on the given schedule.
@@ -124,33 +416,71 @@ func (c *Cron) AddJob(spec string, cmd Job) int64 {
 	return c.Schedule(Parse(spec), cmd)
 }
 
-func (c *Cron) AddOncejob(once time.Time, cmd Job) int64 {
+func (c *Cron) AddOncejob(once time.Time, cmd Job, opts ...EntryOption) int64 {
 	schedule := &OnceSchedule{
 		runed:   false,
 		thetime: once,
 	}
-	return c.Schedule(schedule, cmd)
+	return c.Schedule(schedule, cmd, opts...)
+}
+
+// AddNowjob adds a Job to be run once, right away.
+func (c *Cron) AddNowjob(cmd Job, opts ...EntryOption) int64 {
+	return c.Schedule(&nowSchedule{}, cmd, opts...)
+}
+
+// Schedule adds a Job to the Cron to be run on the given schedule, set up by
+// opts. Once the Cron is shut down, nothing is added and the id returned is 0.
+func (c *Cron) Schedule(schedule Schedule, cmd Job, opts ...EntryOption) int64 {
+	return c.schedule(c.getIncrement(), schedule, cmd, opts)
+}
+
+// Restore adds a Job under an id handed out by an earlier run of the process,
+// e.g. when replaying the journal. Ids given out afterwards stay above it.
+func (c *Cron) Restore(id int64, schedule Schedule, cmd Job, opts ...EntryOption) int64 {
+	for {
+		cur := atomic.LoadInt64(&c.increment)
+		if id <= cur || atomic.CompareAndSwapInt64(&c.increment, cur, id) {
+			break
+		}
+	}
+	return c.schedule(id, schedule, cmd, opts)
 }
 
-// Schedule adds a Job to the Cron to be run on the given schedule.
-func (c *Cron) Schedule(schedule Schedule, cmd Job) int64 {
-	var increment int64
-	increment = c.getIncrement()
+func (c *Cron) schedule(id int64, schedule Schedule, cmd Job, opts []EntryOption) int64 {
 	entry := &Entry{
 		Schedule: schedule,
 		Job:      cmd,
-		Id:       increment,
+		Id:       id,
+	}
+	for _, opt := range opts {
+		opt(entry)
+	}
+	select {
+	case <-c.closing:
+		return 0
+	default:
 	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	if !c.running {
-		c.entries = append(c.entries, entry)
-		return increment
+		c.addEntry(entry)
+		return id
+	}
+	// Jobs schedule their retries here, and may do so while the run loop
+	// is stopping under Shutdown.
+	select {
+	case c.add <- entry:
+		return id
+	case <-c.closing:
+		return 0
 	}
-	c.add <- entry
-	return increment
 }
 
 // Entries returns a snapshot of the cron entries.
 func (c *Cron) Entries() []*Entry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	if c.running {
 		c.snapshot <- nil
 		x := <-c.snapshot
@@ -159,24 +489,54 @@ func (c *Cron) Entries() []*Entry {
 	return c.entrySnapshot()
 }
 
-// Start the cron scheduler in its own go-routine.
+// Start the cron scheduler in its own go-routine. Starting it while it runs
+// does nothing, and so does starting it once it is shut down; it may be
+// started again after Stop.
 func (c *Cron) Start() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	select {
+	case <-c.closing:
+		return
+	default:
+	}
+	if c.running {
+		return
+	}
+	c.ctx, c.cancel = context.WithCancelCause(context.Background())
 	c.running = true
 	go c.run()
 }
 
 // Run the scheduler.. this is private just due to the need to synchronize
-// access to the 'running' state variable.
+// access to the 'running' state variable. It owns the entries until it is
+// stopped.
 func (c *Cron) run() {
-	// Figure out the next activation times for each entry.
-	now := time.Now().Local()
+	// Figure out the next activation times for each entry. Those of the
+	// entries that ran before, in this process or an earlier one, are
+	// counted from then, so that activations missed meanwhile are due.
+	now := c.now()
 
 	for _, entry := range c.entries {
-		entry.Next = entry.Schedule.Next(now)
+		if entry.Paused {
+			continue
+		}
+		since := now
+		switch {
+		case !entry.since.IsZero():
+			since = entry.since
+			entry.since = time.Time{}
+		case !entry.Prev.IsZero():
+			since = entry.Prev
+		}
+		entry.Next = entry.Schedule.Next(since)
 	}
+	heap.Init(&c.entries)
+
+	var timer Timer
+	defer func() { timer.Stop() }()
 	for {
 		// Determine the next entry to run.
-		sort.Sort(byTime(c.entries))
 		var effective time.Time
 		if len(c.entries) == 0 || c.entries[0].Next.IsZero() {
 			// If there are no entries yet, just sleep - it still handles new entries
@@ -186,33 +546,35 @@ func (c *Cron) run() {
 			effective = c.entries[0].Next
 		}
 
+		if timer != nil {
+			timer.Stop()
+		}
+		timer = c.clock.NewTimer(effective.Sub(now))
+
 		select {
-		case now = <-time.After(effective.Sub(now)):
+		case now = <-timer.C():
 			// Run every entry whose next time was this effective time.
-			for i := 0; i < len(c.entries); i++ {
-				if c.entries[i].Next != effective {
-					break
-				}
-				go c.entries[i].Job.Run(c.entries[i].Id)
-				c.entries[i].Prev = c.entries[i].Next
-				c.entries[i].Next = c.entries[i].Schedule.Next(effective)
-				if c.entries[i].Next.IsZero() {
-					c.entries = append(c.entries[:i], c.entries[i+1:]...)
-					i -= 1
+			for _, entry := range c.due(effective) {
+				c.fire(entry, now)
+				if !entry.Next.IsZero() {
+					c.addEntry(entry)
 				}
 			}
 			continue
 
 		case newEntry := <-c.add:
-			c.entries = append(c.entries, newEntry)
 			newEntry.Next = newEntry.Schedule.Next(now)
-		case id := <-c.del:
-			for i, entry := range c.entries {
-				if entry.Id == id {
-					c.entries = append(c.entries[:i], c.entries[i+1:]...)
-				}
-			}
-			continue
+			c.addEntry(newEntry)
+		case req := <-c.del:
+			req.reply <- c.delEntry(req)
+		case req := <-c.pause:
+			req.reply <- c.pauseEntry(req)
+		case req := <-c.resume:
+			req.reply <- c.resumeEntry(req)
+		case req := <-c.update:
+			req.reply <- c.updateEntry(req)
+		case req := <-c.lookup:
+			req.reply <- c.lookupEntry(req)
 		case <-c.snapshot:
 			c.snapshot <- c.entrySnapshot()
 
@@ -220,33 +582,243 @@ func (c *Cron) run() {
 			return
 		}
 
-		// 'now' should be updated after newEntry and snapshot cases.
-		now = time.Now().Local()
+		// 'now' should be updated after every case, so that the next
+		// timer counts from the time it is set.
+		now = c.now()
+	}
+}
+
+// fire runs an entry that is due, and moves it on to its next activation.
+// An entry more than misfireThreshold late has missed its activation, and
+// maybe later ones, and is run as its Misfire says.
+func (c *Cron) fire(e *Entry, now time.Time) {
+	due := e.Next
+	if now.Sub(due) <= c.misfireThreshold {
+		c.startJob(e)
+		e.Prev = due
+		e.Next = e.Schedule.Next(due)
+		return
+	}
+
+	// Count the activations missed, as far as the policy cares.
+	limit := 2
+	if e.Misfire > 0 {
+		limit = int(e.Misfire) + 1
+	}
+	missed := 1
+	for t := e.Schedule.Next(due); missed < limit && !t.IsZero() && !t.After(now); t = e.Schedule.Next(t) {
+		missed++
+	}
+	runs := e.Misfire.runs(missed)
+	for i := 0; i < runs; i++ {
+		c.startJob(e)
+	}
+	if runs < missed {
+		if sj, ok := e.Job.(SkipJob); ok {
+			id := e.Id
+			c.jobs.Add(1)
+			go func() {
+				defer c.jobs.Done()
+				sj.Skip(id, ErrMisfired)
+			}()
+		}
+	}
+	if runs > 0 {
+		e.Prev = now
+	}
+	e.Next = e.Schedule.Next(now)
+}
+
+// startJob hands a job to the executor, keeping track of it for Shutdown,
+// for cancelling it and for its overlap policy.
+func (c *Cron) startJob(e *Entry) {
+	job, id, overlap := e.Job, e.Id, e.Overlap
+	c.runsMu.Lock()
+	runs := c.runs[id]
+	if runs == nil {
+		runs = &jobRuns{runs: make(map[*jobRun]struct{}), origin: e.Origin}
+		c.runs[id] = runs
+	}
+	if len(runs.runs) > 0 && overlap != OverlapAllow {
+		err := ErrStillRunning
+		if overlap > 0 {
+			if runs.queued < int(overlap) {
+				runs.queued++
+				c.runsMu.Unlock()
+				return
+			}
+			err = ErrQueueFull
+		}
+		c.runsMu.Unlock()
+		if sj, ok := job.(SkipJob); ok {
+			c.jobs.Add(1)
+			go func() {
+				defer c.jobs.Done()
+				sj.Skip(id, err)
+			}()
+		}
+		return
+	}
+
+	parent := c.ctx
+	run := &jobRun{}
+	runs.runs[run] = struct{}{}
+	ctx := c.runContext(parent, run)
+	c.runsMu.Unlock()
+
+	c.jobs.Add(1)
+	c.submit(job, id, parent, ctx, runs, run)
+}
+
+// submit hands a run of job to the executor. Once it is over, the runs queued
+// behind it follow, while the job is neither deleted nor stopped.
+func (c *Cron) submit(job Job, id int64, parent, ctx context.Context, runs *jobRuns, run *jobRun) {
+	host := ""
+	if hj, ok := job.(HostJob); ok {
+		host = hj.Host()
+	}
+	c.executor.Submit(host, func() {
+		defer c.jobs.Done()
+		// Runs cancelled while waiting for the executor are left out.
+		if ctx.Err() == nil {
+			c.runJob(ctx, job, id)
+		}
+		run.cancel(nil)
+
+		c.runsMu.Lock()
+		if runs.queued > 0 && c.runs[id] == runs && parent.Err() == nil {
+			runs.queued--
+			ctx := c.runContext(parent, run)
+			c.runsMu.Unlock()
+			c.jobs.Add(1)
+			c.submit(job, id, parent, ctx, runs, run)
+			return
+		}
+		delete(runs.runs, run)
+		if len(runs.runs) == 0 && c.runs[id] == runs {
+			delete(c.runs, id)
+		}
+		c.runsMu.Unlock()
+	})
+}
+
+// runJob runs job, through RunContext if it is a ContextJob, with its
+// Timeout counted from now.
+func (c *Cron) runJob(ctx context.Context, job Job, id int64) {
+	cj, ok := job.(ContextJob)
+	if !ok {
+		job.Run(id)
+		return
+	}
+	if tj, ok := job.(TimeoutJob); ok && tj.Timeout() > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, tj.Timeout())
+		defer cancel()
+	}
+	cj.RunContext(ctx, id)
+}
+
+// runContext returns the context of a run, setting its cancel func. It is
+// called with runsMu held.
+func (c *Cron) runContext(parent context.Context, run *jobRun) context.Context {
+	ctx, cancel := context.WithCancelCause(parent)
+	run.cancel = cancel
+	return ctx
+}
+
+// cancelRuns cancels the runs of the job with the given id that are going
+// on, and those queued, and those of the entries scheduled for it, and
+// reports whether there were any.
+func (c *Cron) cancelRuns(id int64, cause error) bool {
+	c.runsMu.Lock()
+	defer c.runsMu.Unlock()
+	cancelled := false
+	for runsId, runs := range c.runs {
+		if runsId != id && runs.origin != id {
+			continue
+		}
+		for run := range runs.runs {
+			run.cancel(cause)
+		}
+		runs.queued = 0
+		delete(c.runs, runsId)
+		cancelled = cancelled || len(runs.runs) > 0
 	}
+	return cancelled
 }
 
-// Stop the cron scheduler.
+// Stop the cron scheduler, cancelling the jobs that are running. Stopping it
+// when it is not running does nothing.
 func (c *Cron) Stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.running {
+		return
+	}
+	c.halt()
+	c.cancel(ErrCronStopped)
+}
+
+// halt stops the run loop, leaving the jobs that are running alone. It is
+// called with mu held, while running.
+func (c *Cron) halt() {
 	c.stop <- struct{}{}
 	c.running = false
 }
 
+// Shutdown stops the scheduler for good, so that no entry is added or run
+// anymore, and waits for the jobs that are running to return. It gives up
+// waiting when ctx is done, cancelling the jobs and returning its error.
+func (c *Cron) Shutdown(ctx context.Context) error {
+	c.shutdown.Do(func() {
+		close(c.closing)
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.running {
+			c.halt()
+		}
+	})
+
+	done := make(chan struct{})
+	go func() {
+		c.jobs.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		c.mu.Lock()
+		cancel := c.cancel
+		c.mu.Unlock()
+		if cancel != nil {
+			cancel(ErrCronStopped)
+		}
+		return ctx.Err()
+	}
+}
+
 func (c *Cron) getIncrement() int64 {
-	atomic.AddInt64(&c.increment, 1)
-	//c.increment = c.increment + 1
-	return c.increment
+	return atomic.AddInt64(&c.increment, 1)
 }
 
-// entrySnapshot returns a copy of the current cron entry list.
+// entrySnapshot returns a copy of the current cron entry list, the entry due
+// first first.
 func (c *Cron) entrySnapshot() []*Entry {
-	entries := []*Entry{}
+	entries := make([]*Entry, 0, len(c.entries))
 	for _, e := range c.entries {
 		entries = append(entries, &Entry{
 			Schedule: e.Schedule,
 			Next:     e.Next,
 			Prev:     e.Prev,
 			Job:      e.Job,
+			Id:       e.Id,
+			Paused:   e.Paused,
+			Overlap:  e.Overlap,
+			Misfire:  e.Misfire,
+			Origin:   e.Origin,
 		})
 	}
+	sort.Stable(byTime(entries))
 	return entries
 }
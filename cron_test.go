@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"sync"
 	"testing"
@@ -9,16 +10,109 @@ import (
 	. "github.com/smartystreets/goconvey/convey"
 )
 
-// Many tests schedule a job for every second, and then wait at most a second
-// for it to run.  This amount is just slightly larger than 1 second to
-// compensate for a few milliseconds of runtime.
+// Jobs run in their own go-routines, so tests wait at most this long, in
+// real time, for one to run, and this long to be sure it does not.
 const ONE_SECOND = 1*time.Second + 10*time.Millisecond
-const FIVE_SECOND = 5*time.Second + 10*time.Millisecond
+const A_MOMENT = 50 * time.Millisecond
+
+// fakeClock is a Clock that only moves when told to. Like real ones, its
+// timers count from the time on the clock when they are set.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+
+	// Whether a timer was set since the clock was last read or a timer went
+	// off, which is when the Cron waits for its next entry.
+	waiting bool
+}
+
+type fakeTimer struct {
+	clock *fakeClock
+	at    time.Time
+	c     chan time.Time
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.waiting = false
+	return c.now
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{c, c.now.Add(d), make(chan time.Time, 1)}
+	c.timers = append(c.timers, t)
+	c.waiting = true
+	c.fire()
+	return t
+}
+
+// Time is the time on the clock, read by a test rather than the Cron.
+func (c *fakeClock) Time() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock on by d, once the Cron is waiting on a timer, and
+// sets off the timers due.
+func (c *fakeClock) Advance(d time.Duration) {
+	waitFor(func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.waiting
+	})
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	c.fire()
+}
+
+// fire sets off the timers due. It is called with mu held.
+func (c *fakeClock) fire() {
+	pending := c.timers[:0]
+	for _, t := range c.timers {
+		if t.at.After(c.now) {
+			pending = append(pending, t)
+			continue
+		}
+		c.waiting = false
+		t.c <- c.now
+	}
+	c.timers = pending
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.c }
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	for i, other := range t.clock.timers {
+		if other == t {
+			t.clock.timers = append(t.clock.timers[:i], t.clock.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// newTestCron returns a Cron on a fake clock, set to a Monday afternoon.
+func newTestCron(opts ...Option) (*Cron, *fakeClock) {
+	clock := newFakeClock(time.Date(2012, time.July, 9, 14, 45, 0, 0, time.Local))
+	return New(append([]Option{WithClock(clock)}, opts...)...), clock
+}
 
 // Start and stop cron with no entries.
 func TestNoEntries(t *testing.T) {
 
-	cron := New()
+	cron, _ := newTestCron()
 	cron.Start()
 
 	Convey("When have no entries.", t, func() {
@@ -38,14 +132,14 @@ func TestStopCausesJobsToNotRun(t *testing.T) {
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
 
-	cron := New()
+	cron, _ := newTestCron()
 	cron.Start()
 	cron.Stop()
 	cron.AddFunc("* * * * * ?", func(id int64) { wg.Done() })
 	Convey("Start, stop, then add an entry. Verify entry doesn't run.", t, func() {
 		tag := false
 		select {
-		case <-time.After(ONE_SECOND):
+		case <-time.After(A_MOMENT):
 			// No job ran!
 			tag = true
 		case <-wait(wg):
@@ -60,13 +154,14 @@ func TestAddBeforeRunning(t *testing.T) {
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
 
-	cron := New()
+	cron, clock := newTestCron()
 	cron.AddFunc("* * * * * ?", func(id int64) { wg.Done() })
 	cron.Start()
 	defer cron.Stop()
 
-	// Give cron 2 seconds to run our job (which is always activated).
+	// Give cron a second to run our job (which is always activated).
 	Convey("Add a job, start cron, expect it runs.", t, func() {
+		clock.Advance(time.Second)
 		tag := false
 		select {
 		case <-time.After(ONE_SECOND):
@@ -83,18 +178,19 @@ func TestDelBeforeRunning(t *testing.T) {
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
 
-	cron := New()
+	cron, clock := newTestCron()
 	id := cron.AddFunc("*/5 * * * * ?", func(id int64) { wg.Done() })
 	cron.AddFunc("0 0 0 1 1 ?", func(id int64) {})
 	cron.DelJob(id)
 	cron.Start()
 	defer cron.Stop()
 
-	// Give cron 2 seconds to run our job (which is always activated).
+	// Give cron 5 seconds to run our job (which is always activated).
 	Convey("Del a job,del cron,expect it not runs.", t, func() {
+		clock.Advance(5 * time.Second)
 		tag := false
 		select {
-		case <-time.After(FIVE_SECOND):
+		case <-time.After(A_MOMENT):
 			tag = true
 		case <-wait(wg):
 
@@ -103,16 +199,62 @@ func TestDelBeforeRunning(t *testing.T) {
 	})
 }
 
+// Unknown ids are reported, running or not.
+func TestDelUnknownJob(t *testing.T) {
+	cron, _ := newTestCron()
+	id := cron.AddFunc("0 0 0 1 1 ?", func(id int64) {})
+	Convey("Del, pause and resume an unknown job.", t, func() {
+		So(cron.DelJob(id+1), ShouldBeFalse)
+		So(cron.PauseJob(id+1), ShouldBeFalse)
+		cron.Start()
+		defer cron.Stop()
+		So(cron.ResumeJob(id+1), ShouldBeFalse)
+		So(cron.DelJob(id), ShouldBeTrue)
+	})
+}
+
+// Updating a job keeps its id and Prev, and recomputes Next.
+func TestUpdateJob(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+
+	cron, clock := newTestCron()
+	ran := make(chan bool, 1)
+	id := cron.AddFunc("* * * * * ?", func(id int64) { ran <- true })
+	cron.Start()
+	defer cron.Stop()
+	Convey("Update a running job.", t, func() {
+		clock.Advance(time.Second)
+		<-ran
+		So(cron.UpdateJob(id, Parse("0 0 0 1 1 ?"), FuncJob(func(id int64) { wg.Done() })), ShouldBeTrue)
+		entry := cron.Entries()[0]
+		So(entry.Id, ShouldEqual, id)
+		So(entry.Prev.IsZero(), ShouldBeFalse)
+		So(entry.Next, ShouldResemble, Parse("0 0 0 1 1 ?").Next(clock.Time().Local()))
+
+		So(cron.UpdateJob(id, Parse("* * * * * ?"), nil), ShouldBeTrue)
+		clock.Advance(time.Second)
+		tag := false
+		select {
+		case <-time.After(ONE_SECOND):
+		case <-wait(wg):
+			tag = true
+		}
+		So(tag, ShouldBeTrue)
+	})
+}
+
 //
 func TestAddWhileRunning(t *testing.T) {
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
 
-	cron := New()
+	cron, clock := newTestCron()
 	cron.Start()
 	defer cron.Stop()
 	cron.AddFunc("* * * * * ?", func(id int64) { wg.Done() })
 	Convey("Start cron, add a job, expect it runs.", t, func() {
+		clock.Advance(time.Second)
 		tag := false
 		select {
 		case <-time.After(ONE_SECOND):
@@ -129,7 +271,7 @@ func TestDelWhileRunning(t *testing.T) {
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
 
-	cron := New()
+	cron, clock := newTestCron()
 	id := cron.AddFunc("*/5 * * * * ?", func(id int64) { wg.Done() })
 	cron.AddFunc("0 0 0 1 1 ?", func(id int64) {})
 	cron.Start()
@@ -137,10 +279,11 @@ func TestDelWhileRunning(t *testing.T) {
 
 	defer cron.Stop()
 	Convey("Start cron, add a job ,and del it, expect not runs.", t, func() {
-		// Give cron 2 seconds to run our job (which is always activated).
+		// Give cron 5 seconds to run our job (which is always activated).
+		clock.Advance(5 * time.Second)
 		tag := false
 		select {
-		case <-time.After(FIVE_SECOND):
+		case <-time.After(A_MOMENT):
 			tag = true
 		case <-wait(wg):
 
@@ -154,18 +297,17 @@ func TestSnapshotEntries(t *testing.T) {
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
 
-	cron := New()
+	cron, clock := newTestCron()
 	cron.AddFunc("@every 2s", func(id int64) { wg.Done() })
 	cron.Start()
 	defer cron.Stop()
 
 	// Cron should fire in 2 seconds. After 1 second, call Entries.
-	select {
-	case <-time.After(ONE_SECOND):
-		cron.Entries()
-	}
+	clock.Advance(time.Second)
+	cron.Entries()
 	Convey("Test timing with Entries.", t, func() {
 		// Even though Entries was called, the cron should fire at the 2 second mark.
+		clock.Advance(time.Second)
 		tag := false
 		select {
 		case <-time.After(ONE_SECOND):
@@ -185,7 +327,7 @@ func TestMultipleEntries(t *testing.T) {
 	wg := &sync.WaitGroup{}
 	wg.Add(2)
 
-	cron := New()
+	cron, clock := newTestCron()
 	cron.AddFunc("0 0 0 1 1 ?", func(id int64) {})
 	cron.AddFunc("* * * * * ?", func(id int64) { wg.Done() })
 	cron.AddFunc("0 0 0 31 12 ?", func(id int64) {})
@@ -195,6 +337,7 @@ func TestMultipleEntries(t *testing.T) {
 	defer cron.Stop()
 
 	Convey("Test that the entries are correctly sorted.", t, func() {
+		clock.Advance(time.Second)
 		tag := false
 		select {
 		case <-time.After(ONE_SECOND):
@@ -211,7 +354,7 @@ func TestRunningJobTwice(t *testing.T) {
 	wg := &sync.WaitGroup{}
 	wg.Add(2)
 
-	cron := New()
+	cron, clock := newTestCron()
 	cron.AddFunc("0 0 0 1 1 ?", func(id int64) {})
 	cron.AddFunc("0 0 0 31 12 ?", func(id int64) {})
 	cron.AddFunc("* * * * * ?", func(id int64) { wg.Done() })
@@ -219,9 +362,11 @@ func TestRunningJobTwice(t *testing.T) {
 	cron.Start()
 	defer cron.Stop()
 	Convey("Test running the same job twice.", t, func() {
+		clock.Advance(time.Second)
+		clock.Advance(time.Second)
 		tag := false
 		select {
-		case <-time.After(2 * ONE_SECOND):
+		case <-time.After(ONE_SECOND):
 
 		case <-wait(wg):
 			tag = true
@@ -235,7 +380,7 @@ func TestRunningMultipleSchedules(t *testing.T) {
 	wg := &sync.WaitGroup{}
 	wg.Add(2)
 
-	cron := New()
+	cron, clock := newTestCron()
 	cron.AddFunc("0 0 0 1 1 ?", func(id int64) {})
 	cron.AddFunc("0 0 0 31 12 ?", func(id int64) {})
 	cron.AddFunc("* * * * * ?", func(id int64) { wg.Done() })
@@ -246,9 +391,10 @@ func TestRunningMultipleSchedules(t *testing.T) {
 	cron.Start()
 	defer cron.Stop()
 	Convey("Test running multischedules", t, func() {
+		clock.Advance(time.Second)
 		tag := false
 		select {
-		case <-time.After(2 * ONE_SECOND):
+		case <-time.After(ONE_SECOND):
 
 		case <-wait(wg):
 			tag = true
@@ -262,15 +408,16 @@ func TestLocalTimezone(t *testing.T) {
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
 
-	now := time.Now().Local()
+	cron, clock := newTestCron()
+	now := clock.Time().Local()
 	spec := fmt.Sprintf("%d %d %d %d %d ?",
 		now.Second()+1, now.Minute(), now.Hour(), now.Day(), now.Month())
 
-	cron := New()
 	cron.AddFunc(spec, func(id int64) { wg.Done() })
 	cron.Start()
 	defer cron.Stop()
 	Convey("Test that the cron is run in the local time zone (as opposed to UTC).", t, func() {
+		clock.Advance(time.Second)
 		tag := false
 		select {
 		case <-time.After(ONE_SECOND):
@@ -296,7 +443,7 @@ func TestJob(t *testing.T) {
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
 
-	cron := New()
+	cron, clock := newTestCron()
 	cron.AddJob("0 0 0 30 Feb ?", testJob{wg, "job0"})
 	cron.AddJob("0 0 0 1 1 ?", testJob{wg, "job1"})
 	cron.AddJob("* * * * * ?", testJob{wg, "job2"})
@@ -307,6 +454,7 @@ func TestJob(t *testing.T) {
 	cron.Start()
 	defer cron.Stop()
 	Convey("Simple test using Runnables.", t, func() {
+		clock.Advance(time.Second)
 		tag := false
 		select {
 		case <-time.After(ONE_SECOND):
@@ -334,14 +482,15 @@ func TestOncejob(t *testing.T) {
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
 
-	cron := New()
+	cron, clock := newTestCron()
 	cron.Start()
-	cron.AddOncejob(time.Now().Add(time.Second*2), FuncJob(func(id int64) { wg.Done() }))
+	cron.AddOncejob(clock.Time().Add(time.Second*2), FuncJob(func(id int64) { wg.Done() }))
 	defer cron.Stop()
 	Convey("Start, Once job.", t, func() {
+		clock.Advance(2 * time.Second)
 		tag := false
 		select {
-		case <-time.After(FIVE_SECOND):
+		case <-time.After(ONE_SECOND):
 			// No job ran!
 
 		case <-wait(wg):
@@ -351,6 +500,257 @@ func TestOncejob(t *testing.T) {
 	})
 }
 
+// The clock of a Cron decides when its entries are due, late or in daylight
+// saving time.
+func TestClock(t *testing.T) {
+	Convey("Activations missed while the clock jumped are made up for.", t, func() {
+		cron, clock := newTestCron()
+		job := newSlowJob()
+		close(job.release)
+		cron.Schedule(Parse("@hourly"), job, WithMisfire(MisfireFireAll(5)))
+		cron.Start()
+		defer cron.Stop()
+		clock.Advance(3 * time.Hour)
+		So(waitFor(func() bool { return len(job.started) == 3 }), ShouldBeTrue)
+	})
+
+	Convey("An hour the clock turns back over runs twice.", t, func() {
+		utc := func(v string) time.Time {
+			t, _ := time.Parse(time.RFC3339, v)
+			return t
+		}
+		clock := newFakeClock(utc("2026-11-01T05:00:00Z"))
+		cron := New(WithClock(clock))
+		job := newSlowJob()
+		close(job.release)
+		cron.AddJob("CRON_TZ=America/New_York 0 30 1 * * *", job)
+		cron.Start()
+		defer cron.Stop()
+
+		clock.Advance(30 * time.Minute)
+		So(waitFor(func() bool { return len(job.started) == 1 }), ShouldBeTrue)
+		clock.Advance(time.Hour)
+		So(waitFor(func() bool { return len(job.started) == 2 }), ShouldBeTrue)
+		So(cron.Entries()[0].Next.Equal(utc("2026-11-02T06:30:00Z")), ShouldBeTrue)
+	})
+
+	Convey("Deleting another job does not put off the next activation.", t, func() {
+		cron, clock := newTestCron()
+		job := newSlowJob()
+		close(job.release)
+		cron.Schedule(tickSchedule{3 * time.Second}, job, WithMisfire(MisfireSkip))
+		other := cron.AddFunc("0 0 0 1 1 ?", func(int64) {})
+		cron.Start()
+		defer cron.Stop()
+
+		clock.Advance(3 * time.Second)
+		So(waitFor(func() bool { return len(job.started) == 1 }), ShouldBeTrue)
+		clock.Advance(2 * time.Second)
+		So(cron.DelJob(other), ShouldBeTrue)
+		clock.Advance(time.Second)
+		So(waitFor(func() bool { return len(job.started) == 2 }), ShouldBeTrue)
+		So(len(job.skips), ShouldEqual, 0)
+	})
+}
+
+// The Cron may be used from any number of go-routines, and be started and
+// stopped any number of times.
+func TestConcurrency(t *testing.T) {
+	ran := func(ch chan bool) bool {
+		select {
+		case <-ch:
+			return true
+		case <-time.After(ONE_SECOND):
+			return false
+		}
+	}
+
+	Convey("Stopping before starting, and starting or stopping twice, do nothing.", t, func() {
+		cron, clock := newTestCron()
+		cron.Stop()
+		cron.Start()
+		cron.Start()
+		runs := make(chan bool, 10)
+		cron.AddFunc("* * * * * ?", func(int64) { runs <- true })
+		clock.Advance(time.Second)
+		So(ran(runs), ShouldBeTrue)
+		cron.Stop()
+		cron.Stop()
+	})
+
+	Convey("A stopped Cron starts again with its entries.", t, func() {
+		cron, clock := newTestCron()
+		runs := make(chan bool, 10)
+		cron.AddFunc("* * * * * ?", func(int64) { runs <- true })
+		cron.Start()
+		clock.Advance(time.Second)
+		So(ran(runs), ShouldBeTrue)
+		cron.Stop()
+
+		cron.Start()
+		defer cron.Stop()
+		clock.Advance(time.Second)
+		So(ran(runs), ShouldBeTrue)
+	})
+
+	Convey("The api may be called from many go-routines at once.", t, func() {
+		cron := New()
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 100; j++ {
+					id := cron.AddFunc("0 0 0 1 1 ?", func(int64) {})
+					cron.PauseJob(id)
+					cron.ResumeJob(id)
+					cron.UpdateJob(id, Every(time.Hour), nil)
+					cron.Entries()
+					cron.DelJob(id)
+				}
+			}()
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				cron.Start()
+				cron.Stop()
+			}
+		}()
+		wg.Wait()
+		So(len(cron.Entries()), ShouldEqual, 0)
+	})
+}
+
+// ctxJob waits for its context to be cancelled, and passes on why it was.
+type ctxJob struct {
+	timeout time.Duration
+	started chan bool
+	causes  chan error
+}
+
+func newCtxJob(timeout time.Duration) *ctxJob {
+	return &ctxJob{timeout, make(chan bool, 1), make(chan error, 1)}
+}
+
+func (j *ctxJob) Run(id int64) { panic("Run called on a ContextJob") }
+
+func (j *ctxJob) RunContext(ctx context.Context, id int64) {
+	j.started <- true
+	<-ctx.Done()
+	j.causes <- context.Cause(ctx)
+}
+
+func (j *ctxJob) Timeout() time.Duration { return j.timeout }
+
+func (j *ctxJob) cause() error {
+	select {
+	case err := <-j.causes:
+		return err
+	case <-time.After(ONE_SECOND):
+		return nil
+	}
+}
+
+// ContextJobs are cancelled when deleted, stopped or late.
+func TestContextJob(t *testing.T) {
+	Convey("Deleting a job cancels its run.", t, func() {
+		cron := New()
+		job := newCtxJob(0)
+		id := cron.AddNowjob(job)
+		cron.Start()
+		defer cron.Stop()
+		<-job.started
+		So(cron.DelJob(id), ShouldBeTrue)
+		So(job.cause(), ShouldEqual, ErrJobDeleted)
+		So(cron.DelJob(id), ShouldBeFalse)
+	})
+
+	Convey("Stopping the cron cancels the jobs running.", t, func() {
+		cron := New()
+		job := newCtxJob(0)
+		cron.AddNowjob(job)
+		cron.Start()
+		<-job.started
+		cron.Stop()
+		So(job.cause(), ShouldEqual, ErrCronStopped)
+	})
+
+	Convey("A job running past its timeout is cancelled.", t, func() {
+		cron := New()
+		job := newCtxJob(50 * time.Millisecond)
+		cron.AddNowjob(job)
+		cron.Start()
+		defer cron.Stop()
+		<-job.started
+		So(job.cause(), ShouldEqual, context.DeadlineExceeded)
+	})
+
+	Convey("Shutdown cancels the jobs still running at its deadline.", t, func() {
+		cron := New()
+		job := newCtxJob(0)
+		cron.AddNowjob(job)
+		cron.Start()
+		<-job.started
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		So(cron.Shutdown(ctx), ShouldEqual, context.DeadlineExceeded)
+		So(job.cause(), ShouldEqual, ErrCronStopped)
+	})
+}
+
+// Shutdown waits for running jobs, until its deadline, and takes no new ones.
+func TestShutdown(t *testing.T) {
+	Convey("Shutdown waits for the jobs that are running.", t, func() {
+		cron := New()
+		done := make(chan bool, 1)
+		cron.AddNowjob(FuncJob(func(id int64) {
+			time.Sleep(100 * time.Millisecond)
+			done <- true
+		}))
+		cron.Start()
+		time.Sleep(10 * time.Millisecond)
+
+		ctx, cancel := context.WithTimeout(context.Background(), ONE_SECOND)
+		defer cancel()
+		So(cron.Shutdown(ctx), ShouldBeNil)
+		So(len(done), ShouldEqual, 1)
+		So(cron.Shutdown(ctx), ShouldBeNil)
+	})
+
+	Convey("Shutdown gives up at its deadline.", t, func() {
+		cron := New()
+		release := make(chan bool)
+		defer close(release)
+		cron.AddNowjob(FuncJob(func(id int64) { <-release }))
+		cron.Start()
+		time.Sleep(10 * time.Millisecond)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		So(cron.Shutdown(ctx), ShouldEqual, context.DeadlineExceeded)
+	})
+
+	Convey("Jobs adding entries while it shuts down are not blocked.", t, func() {
+		cron := New()
+		added := make(chan int64, 1)
+		cron.AddNowjob(FuncJob(func(id int64) {
+			time.Sleep(50 * time.Millisecond)
+			added <- cron.AddOncejob(time.Now().Add(time.Hour), FuncJob(func(int64) {}))
+		}))
+		cron.Start()
+		time.Sleep(10 * time.Millisecond)
+
+		ctx, cancel := context.WithTimeout(context.Background(), ONE_SECOND)
+		defer cancel()
+		So(cron.Shutdown(ctx), ShouldBeNil)
+		So(<-added, ShouldEqual, 0)
+		So(cron.AddFunc("* * * * * ?", func(int64) {}), ShouldEqual, 0)
+		So(len(cron.Entries()), ShouldEqual, 0)
+	})
+}
+
 func wait(wg *sync.WaitGroup) chan bool {
 	ch := make(chan bool)
 	go func() {
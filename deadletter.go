@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Attempt is the outcome of one call made for a job.
+type Attempt struct {
+	Attempt int
+	Time    time.Time
+	Status  int    `json:",omitempty"`
+	Error   string `json:",omitempty"`
+}
+
+// DeadLetter is a callback that failed on every attempt it was allowed.
+type DeadLetter struct {
+	// The id of the dead letter, and of the job it was made for.
+	Id  int64
+	Job int64
+
+	Bean     Bean
+	Attempts []Attempt
+	Failed   time.Time
+
+	// When the callback was last sent again, and the id of the job doing so.
+	Replayed  time.Time `json:",omitempty"`
+	ReplayJob int64     `json:",omitempty"`
+}
+
+// DeadLetters keeps dead letters in memory and in an append-only file, one
+// JSON line per change; the last line for an id wins when it is read back.
+type DeadLetters struct {
+	mu        sync.Mutex
+	log       *Logbk
+	letters   []*DeadLetter
+	index     map[int64]*DeadLetter
+	increment int64
+}
+
+// NewDeadLetters opens the dead letter file, reading back what it holds.
+func NewDeadLetters(filename string) (*DeadLetters, error) {
+	d := &DeadLetters{
+		index:     make(map[int64]*DeadLetter),
+		increment: time.Now().UnixNano(),
+	}
+	err := ReadJson(filename, func(data []byte) error {
+		dl := &DeadLetter{}
+		if err := json.Unmarshal(data, dl); err != nil {
+			return err
+		}
+		d.put(dl)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if d.log, err = Newbk(filename); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// put stores dl in memory, replacing the letter with the same id.
+func (d *DeadLetters) put(dl *DeadLetter) {
+	if old, ok := d.index[dl.Id]; ok {
+		*old = *dl
+		return
+	}
+	d.letters = append(d.letters, dl)
+	d.index[dl.Id] = dl
+	if dl.Id > d.increment {
+		d.increment = dl.Id
+	}
+}
+
+// Add records a callback that failed for good and returns its letter.
+func (d *DeadLetters) Add(job int64, b Bean, attempts []Attempt) *DeadLetter {
+	dl := &DeadLetter{
+		Id:       atomic.AddInt64(&d.increment, 1),
+		Job:      job,
+		Bean:     b,
+		Attempts: attempts,
+		Failed:   time.Now(),
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.put(dl)
+	d.log.WriteJson(dl)
+	return dl
+}
+
+// List returns a copy of every dead letter, oldest first.
+func (d *DeadLetters) List() []DeadLetter {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	letters := make([]DeadLetter, 0, len(d.letters))
+	for _, dl := range d.letters {
+		letters = append(letters, *dl)
+	}
+	return letters
+}
+
+// Replay sends the callback of a dead letter again, as a now job on c. It
+// returns the id of that job, and false if there is no such letter.
+func (d *DeadLetters) Replay(id int64, c *Cron) (int64, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	dl, ok := d.index[id]
+	if !ok {
+		return 0, false
+	}
+	now := time.Now()
+	bean := dl.Bean
+	bean.Time, bean.Method, bean.Schedule = now, "now", now.Format(onceLayout)
+	bean.Id = c.AddNowjob(beanJob(c, bean))
+	journal(bean)
+
+	dl.Replayed, dl.ReplayJob = now, bean.Id
+	d.log.WriteJson(dl)
+	return bean.Id, true
+}
+
+// Close closes the dead letter file.
+func (d *DeadLetters) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.log.Close()
+}
+
+// deadLetter records a failed callback, if dead letters are kept.
+func deadLetter(job int64, b Bean, attempts []Attempt) {
+	if deadletters != nil {
+		deadletters.Add(job, b, attempts)
+	}
+}
+
+// deadLettersHandler serves
+//
+//	GET  /deadletters              lists the dead letters
+//	POST /deadletters/{id}/replay  sends the callback of one again
+func deadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "application/json")
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/deadletters"), "/")
+	if path == "" {
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			OutputJson(w, 0, "不支持的方法", nil)
+			return
+		}
+		OutputJson(w, 1, "ok", deadletters.List())
+		return
+	}
+
+	parts := strings.Split(path, "/")
+	id, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || len(parts) != 2 || parts[1] != "replay" {
+		w.WriteHeader(http.StatusNotFound)
+		OutputJson(w, 0, "路径错误", nil)
+		return
+	}
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		OutputJson(w, 0, "不支持的方法", nil)
+		return
+	}
+	jid, ok := deadletters.Replay(id, MainCron)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		OutputJson(w, 0, "死信不存在", nil)
+		return
+	}
+	OutputJson(w, 1, "ok", map[string]interface{}{"Id": jid})
+}
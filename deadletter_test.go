@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func deadLettersRequest(method, path string) (int, []byte) {
+	w := httptest.NewRecorder()
+	deadLettersHandler(w, httptest.NewRequest(method, path, nil))
+	return w.Code, w.Body.Bytes()
+}
+
+func TestDeadLetters(t *testing.T) {
+	defer testMain()()
+	MainCron.Start()
+	defer MainCron.Stop()
+
+	var hits int32
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer target.Close()
+
+	bean := Bean{Method: "now", Url: target.URL, Retry: &Retry{Attempts: 2, Backoff: 100 * time.Millisecond}}
+	id := MainCron.AddNowjob(beanJob(MainCron, bean))
+	time.Sleep(500 * time.Millisecond)
+
+	Convey("A callback that exhausts its retries becomes a dead letter.", t, func() {
+		So(atomic.LoadInt32(&hits), ShouldEqual, 2)
+		letters := deadletters.List()
+		So(len(letters), ShouldEqual, 1)
+		So(letters[0].Job, ShouldEqual, id)
+		So(letters[0].Bean.Url, ShouldEqual, target.URL)
+		So(len(letters[0].Attempts), ShouldEqual, 2)
+		So(letters[0].Attempts[1].Status, ShouldEqual, http.StatusBadGateway)
+		So(letters[0].Failed.IsZero(), ShouldBeFalse)
+	})
+
+	Convey("Dead letters are listed and can be replayed.", t, func() {
+		code, body := deadLettersRequest("GET", "/deadletters")
+		So(code, ShouldEqual, http.StatusOK)
+		var res struct {
+			Ret  int
+			Data []DeadLetter
+		}
+		So(json.Unmarshal(body, &res), ShouldBeNil)
+		So(len(res.Data), ShouldEqual, 1)
+
+		path := "/deadletters/" + strconv.FormatInt(res.Data[0].Id, 10) + "/replay"
+		code, _ = deadLettersRequest("POST", path)
+		So(code, ShouldEqual, http.StatusOK)
+		time.Sleep(500 * time.Millisecond)
+		So(atomic.LoadInt32(&hits), ShouldEqual, 4)
+		So(len(deadletters.List()), ShouldEqual, 2)
+
+		code, _ = deadLettersRequest("POST", "/deadletters/1/replay")
+		So(code, ShouldEqual, http.StatusNotFound)
+		code, _ = deadLettersRequest("GET", path)
+		So(code, ShouldEqual, http.StatusMethodNotAllowed)
+	})
+
+	Convey("Dead letters are read back from their file.", t, func() {
+		d, err := NewDeadLetters(deadletters.log.logfile.Name())
+		So(err, ShouldBeNil)
+		defer d.Close()
+		letters := d.List()
+		So(len(letters), ShouldEqual, 2)
+		So(letters[0].Replayed.IsZero(), ShouldBeFalse)
+		So(letters[0].ReplayJob, ShouldNotEqual, 0)
+	})
+}
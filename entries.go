@@ -0,0 +1,84 @@
+package main
+
+import (
+	"container/heap"
+	"time"
+)
+
+// entryHeap is the entry table of a Cron, a min-heap on Next with the zero
+// time last, so that the entry due first is always at the top. Entries keep
+// their place in it in Entry.index, and the Cron indexes them by id, and by
+// Origin, so that any one of them is found at once and added, moved or
+// removed in O(log n).
+type entryHeap []*Entry
+
+func (h entryHeap) Len() int           { return len(h) }
+func (h entryHeap) Less(i, j int) bool { return byTime(h).Less(i, j) }
+
+func (h entryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *entryHeap) Push(x interface{}) {
+	e := x.(*Entry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *entryHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	e.index = -1
+	return e
+}
+
+// addEntry puts e in the table.
+func (c *Cron) addEntry(e *Entry) {
+	heap.Push(&c.entries, e)
+	c.index[e.Id] = e
+	if e.Origin != 0 {
+		if c.followers[e.Origin] == nil {
+			c.followers[e.Origin] = make(map[int64]*Entry)
+		}
+		c.followers[e.Origin][e.Id] = e
+	}
+}
+
+// removeEntry takes e out of the table.
+func (c *Cron) removeEntry(e *Entry) {
+	heap.Remove(&c.entries, e.index)
+	c.unindex(e)
+}
+
+// unindex forgets e, taken out of the table, by id and by origin.
+func (c *Cron) unindex(e *Entry) {
+	delete(c.index, e.Id)
+	if e.Origin != 0 {
+		delete(c.followers[e.Origin], e.Id)
+		if len(c.followers[e.Origin]) == 0 {
+			delete(c.followers, e.Origin)
+		}
+	}
+}
+
+// setNext moves e to its place in the table for its Next time.
+func (c *Cron) setNext(e *Entry, next time.Time) {
+	e.Next = next
+	heap.Fix(&c.entries, e.index)
+}
+
+// due takes the entries due at effective, the earliest Next time, out of the
+// table.
+func (c *Cron) due(effective time.Time) []*Entry {
+	var due []*Entry
+	for len(c.entries) > 0 && c.entries[0].Next == effective {
+		e := heap.Pop(&c.entries).(*Entry)
+		c.unindex(e)
+		due = append(due, e)
+	}
+	return due
+}
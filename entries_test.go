@@ -0,0 +1,149 @@
+package main
+
+import (
+	"container/heap"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// heapCron returns a Cron holding n once jobs, a second apart from now on and
+// shuffled, with their Next times worked out as if it had started.
+func heapCron(n int, now time.Time) *Cron {
+	cron := New()
+	for _, i := range rand.Perm(n) {
+		cron.AddOncejob(now.Add(time.Duration(i+1)*time.Second), FuncJob(func(int64) {}))
+	}
+	for _, e := range cron.entries {
+		e.Next = e.Schedule.Next(now)
+	}
+	heap.Init(&cron.entries)
+	return cron
+}
+
+func TestEntryTable(t *testing.T) {
+	now := time.Now()
+
+	Convey("Entries come out of the table in the order they are due.", t, func() {
+		cron := heapCron(1000, now)
+		for i := 0; i < 1000; i++ {
+			due := cron.due(cron.entries[0].Next)
+			So(len(due), ShouldEqual, 1)
+			So(due[0].Next, ShouldResemble, now.Add(time.Duration(i+1)*time.Second))
+		}
+		So(len(cron.index), ShouldEqual, 0)
+	})
+
+	Convey("Entries are found by id wherever they are.", t, func() {
+		cron := heapCron(1000, now)
+		ids := make([]int64, 0, 1000)
+		for id := range cron.index {
+			ids = append(ids, id)
+		}
+		for _, id := range ids[:500] {
+			So(cron.DelJob(id), ShouldBeTrue)
+			So(cron.DelJob(id), ShouldBeFalse)
+		}
+		So(cron.PauseJob(ids[500]), ShouldBeTrue)
+		So(len(cron.entries), ShouldEqual, 500)
+
+		entries := cron.Entries()
+		So(sort.IsSorted(byTime(entries)), ShouldBeTrue)
+		So(entries[len(entries)-1].Id, ShouldEqual, ids[500])
+		for i, e := range cron.entries {
+			So(e.index, ShouldEqual, i)
+			So(cron.index[e.Id], ShouldEqual, e)
+		}
+	})
+}
+
+func TestFollowers(t *testing.T) {
+	Convey("Entries scheduled for a job are deleted with it, even once it is gone.", t, func() {
+		cron := New()
+		at := time.Now().Add(time.Hour)
+		origin := cron.AddOncejob(at, FuncJob(func(int64) {}))
+		first := cron.AddOncejob(at, FuncJob(func(int64) {}), WithOrigin(origin))
+		cron.AddOncejob(at, FuncJob(func(int64) {}), WithOrigin(origin))
+		other := cron.AddOncejob(at, FuncJob(func(int64) {}))
+
+		So(cron.DelJob(first), ShouldBeTrue)
+		So(len(cron.followers[origin]), ShouldEqual, 1)
+		So(cron.DelJob(origin), ShouldBeTrue)
+		So(cron.DelJob(origin), ShouldBeFalse)
+		So(len(cron.entries), ShouldEqual, 1)
+		So(cron.entries[0].Id, ShouldEqual, other)
+		So(len(cron.followers), ShouldEqual, 0)
+	})
+}
+
+var benchSizes = []struct {
+	name string
+	n    int
+}{
+	{"100k", 100000},
+	{"1M", 1000000},
+}
+
+// BenchmarkNextDue times finding the entry due next and putting it back for
+// its next run, as the run loop does on every activation, against sorting
+// all the entries, as it used to.
+func BenchmarkNextDue(b *testing.B) {
+	for _, size := range benchSizes {
+		b.Run("heap-"+size.name, func(b *testing.B) {
+			cron := heapCron(size.n, time.Now())
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for _, e := range cron.due(cron.entries[0].Next) {
+					e.Next = e.Next.Add(time.Duration(size.n) * time.Second)
+					cron.addEntry(e)
+				}
+			}
+		})
+		b.Run("sort-"+size.name, func(b *testing.B) {
+			entries := heapCron(size.n, time.Now()).entries
+			sort.Sort(byTime(entries))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				entries[0].Next = entries[0].Next.Add(time.Duration(size.n) * time.Second)
+				sort.Sort(byTime(entries))
+			}
+		})
+	}
+}
+
+// BenchmarkDelJob times deleting an entry by id, and adding another in its
+// place, against finding it by a scan, as DelJob used to.
+func BenchmarkDelJob(b *testing.B) {
+	for _, size := range benchSizes {
+		b.Run("index-"+size.name, func(b *testing.B) {
+			now := time.Now()
+			cron := heapCron(size.n, now)
+			ids := make([]int64, 0, size.n)
+			for id := range cron.index {
+				ids = append(ids, id)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				cron.DelJob(ids[i%len(ids)])
+				ids[i%len(ids)] = cron.AddOncejob(now.Add(time.Hour), FuncJob(func(int64) {}))
+			}
+		})
+		b.Run("scan-"+size.name, func(b *testing.B) {
+			entries := []*Entry(heapCron(size.n, time.Now()).entries)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				id := entries[rand.Intn(len(entries))].Id
+				for j, e := range entries {
+					if e.Id == id {
+						entries = append(entries[:j], entries[j+1:]...)
+						break
+					}
+				}
+				entries = append(entries, &Entry{Id: id})
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+)
+
+// Executor runs the jobs of a Cron, at most MaxInFlight at a time and at
+// most MaxPerHost at a time against any one host; 0 means no limit. Jobs over
+// either limit wait and start as room is made: those for one host in the
+// order they came in, and the hosts with room taking turns, so that a job for
+// a busy host does not hold up those for others. Only running jobs have a
+// go-routine.
+type Executor struct {
+	MaxInFlight int
+	MaxPerHost  int
+
+	mu      sync.Mutex
+	queued  int
+	running int
+	hosts   map[string]*hostQueue
+	// The hosts with jobs waiting and room to run one, in turn.
+	ready []*hostQueue
+}
+
+// hostQueue holds the jobs of an Executor for one host.
+type hostQueue struct {
+	name  string
+	stats HostStats
+	tasks []func()
+	ready bool
+}
+
+// HostStats counts the jobs of an Executor for one host.
+type HostStats struct {
+	Queued  int
+	Running int
+}
+
+// ExecutorStats is a snapshot of the jobs of an Executor.
+type ExecutorStats struct {
+	MaxInFlight int
+	MaxPerHost  int
+	Queued      int
+	Running     int
+	Hosts       map[string]HostStats
+}
+
+// NewExecutor returns an Executor with the given limits.
+func NewExecutor(maxInFlight, maxPerHost int) *Executor {
+	return &Executor{
+		MaxInFlight: maxInFlight,
+		MaxPerHost:  maxPerHost,
+		hosts:       make(map[string]*hostQueue),
+	}
+}
+
+// Submit runs fn, now or once there is room for it. An empty host is not
+// limited by MaxPerHost.
+func (e *Executor) Submit(host string, fn func()) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	h := e.host(host)
+	h.tasks = append(h.tasks, fn)
+	h.stats.Queued++
+	e.queued++
+	e.markReady(h)
+	e.dispatch()
+}
+
+// host returns the queue of host, creating it if needed. It is called with
+// mu held.
+func (e *Executor) host(host string) *hostQueue {
+	h, ok := e.hosts[host]
+	if !ok {
+		h = &hostQueue{name: host}
+		e.hosts[host] = h
+	}
+	return h
+}
+
+// markReady gives h a turn if it has jobs waiting and room to run one. It is
+// called with mu held.
+func (e *Executor) markReady(h *hostQueue) {
+	if h.ready || len(h.tasks) == 0 {
+		return
+	}
+	if h.name != "" && e.MaxPerHost > 0 && h.stats.Running >= e.MaxPerHost {
+		return
+	}
+	h.ready = true
+	e.ready = append(e.ready, h)
+}
+
+// dispatch starts the queued jobs there is room for, one from each ready
+// host in turn. It is called with mu held.
+func (e *Executor) dispatch() {
+	for len(e.ready) > 0 && (e.MaxInFlight <= 0 || e.running < e.MaxInFlight) {
+		h := e.ready[0]
+		e.ready[0] = nil
+		e.ready = e.ready[1:]
+		h.ready = false
+
+		fn := h.tasks[0]
+		h.tasks[0] = nil
+		h.tasks = h.tasks[1:]
+		h.stats.Queued--
+		h.stats.Running++
+		e.queued--
+		e.running++
+		go e.run(h, fn)
+		e.markReady(h)
+	}
+}
+
+func (e *Executor) run(h *hostQueue, fn func()) {
+	defer func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		e.running--
+		if h.stats.Running--; h.stats.Running == 0 && h.stats.Queued == 0 {
+			delete(e.hosts, h.name)
+		}
+		e.markReady(h)
+		e.dispatch()
+	}()
+	fn()
+}
+
+// Stats returns how many jobs are queued and running, overall and by host.
+func (e *Executor) Stats() ExecutorStats {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	stats := ExecutorStats{
+		MaxInFlight: e.MaxInFlight,
+		MaxPerHost:  e.MaxPerHost,
+		Queued:      e.queued,
+		Running:     e.running,
+		Hosts:       make(map[string]HostStats, len(e.hosts)),
+	}
+	for host, h := range e.hosts {
+		stats.Hosts[host] = h.stats
+	}
+	return stats
+}
+
+// statsHandler serves
+//
+//	GET /stats  how many jobs are queued and running, overall and by host
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "application/json")
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		OutputJson(w, 0, "不支持的方法", nil)
+		return
+	}
+	OutputJson(w, 1, "ok", MainCron.Executor().Stats())
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// waitFor polls cond for up to a second.
+func waitFor(cond func() bool) bool {
+	for i := 0; i < 100; i++ {
+		if cond() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func TestExecutor(t *testing.T) {
+	Convey("Jobs over the limits wait, in order, for room.", t, func() {
+		e := NewExecutor(2, 1)
+		var (
+			mu      sync.Mutex
+			started []string
+		)
+		release := make(chan bool)
+		submit := func(host, name string) {
+			e.Submit(host, func() {
+				mu.Lock()
+				started = append(started, name)
+				mu.Unlock()
+				<-release
+			})
+		}
+		startedNames := func() []string {
+			mu.Lock()
+			defer mu.Unlock()
+			return append([]string(nil), started...)
+		}
+
+		submit("a", "a1")
+		submit("a", "a2")
+		submit("b", "b1")
+		submit("c", "c1")
+		So(waitFor(func() bool { return len(startedNames()) == 2 }), ShouldBeTrue)
+		So(startedNames(), ShouldContain, "a1")
+		So(startedNames(), ShouldContain, "b1")
+
+		stats := e.Stats()
+		So(stats.Running, ShouldEqual, 2)
+		So(stats.Queued, ShouldEqual, 2)
+		So(stats.Hosts["a"], ShouldResemble, HostStats{Queued: 1, Running: 1})
+		So(stats.Hosts["c"], ShouldResemble, HostStats{Queued: 1})
+
+		// Either a2 or c1 goes next, as a1 or b1 makes room.
+		release <- true
+		So(waitFor(func() bool { return len(startedNames()) == 3 }), ShouldBeTrue)
+		release <- true
+		So(waitFor(func() bool { return len(startedNames()) == 4 }), ShouldBeTrue)
+		So(startedNames()[2:], ShouldContain, "a2")
+		So(startedNames()[2:], ShouldContain, "c1")
+
+		release <- true
+		release <- true
+		So(waitFor(func() bool { return e.Stats().Running == 0 }), ShouldBeTrue)
+		So(e.Stats().Hosts, ShouldBeEmpty)
+	})
+
+	Convey("A burst for one host runs in order and does not hold up others.", t, func() {
+		e := NewExecutor(2, 1)
+		var (
+			mu      sync.Mutex
+			started []int
+		)
+		release, other := make(chan bool), make(chan bool)
+		for i := 0; i < 1000; i++ {
+			i := i
+			e.Submit("a", func() {
+				mu.Lock()
+				started = append(started, i)
+				mu.Unlock()
+				<-release
+			})
+		}
+		e.Submit("b", func() { close(other) })
+		So(waitFor(func() bool {
+			select {
+			case <-other:
+				return true
+			default:
+				return false
+			}
+		}), ShouldBeTrue)
+		So(e.Stats().Hosts["a"], ShouldResemble, HostStats{Queued: 999, Running: 1})
+
+		close(release)
+		So(waitFor(func() bool { return e.Stats().Running == 0 }), ShouldBeTrue)
+		mu.Lock()
+		defer mu.Unlock()
+		inOrder := make([]int, 1000)
+		for i := range inOrder {
+			inOrder[i] = i
+		}
+		So(started, ShouldResemble, inOrder)
+		So(e.Stats().Hosts, ShouldBeEmpty)
+	})
+
+	Convey("No limits run everything at once.", t, func() {
+		e := NewExecutor(0, 0)
+		var wg sync.WaitGroup
+		wg.Add(10)
+		release := make(chan bool)
+		for i := 0; i < 10; i++ {
+			e.Submit("a", func() {
+				wg.Done()
+				<-release
+			})
+		}
+		wg.Wait()
+		So(e.Stats().Running, ShouldEqual, 10)
+		close(release)
+	})
+}
+
+func TestCronExecutor(t *testing.T) {
+	defer testMain()()
+	MainCron = New(WithExecutor(NewExecutor(1, 0)))
+	MainCron.Start()
+	defer MainCron.Stop()
+
+	Convey("Jobs due at once are run by the executor, one at a time.", t, func() {
+		job := newSlowJob()
+		MainCron.AddNowjob(job)
+		MainCron.AddNowjob(job)
+		So(waitFor(func() bool { return MainCron.Executor().Stats().Queued == 1 }), ShouldBeTrue)
+		So(len(job.started), ShouldEqual, 1)
+
+		job.release <- true
+		So(waitFor(func() bool { return len(job.started) == 2 }), ShouldBeTrue)
+		job.release <- true
+	})
+
+	Convey("A job deleted while it waits is left out.", t, func() {
+		job, waiting := newSlowJob(), newCtxJob(0)
+		MainCron.AddNowjob(job)
+		id := MainCron.AddNowjob(waiting)
+		So(waitFor(func() bool { return MainCron.Executor().Stats().Queued == 1 }), ShouldBeTrue)
+		So(MainCron.DelJob(id), ShouldBeTrue)
+		job.release <- true
+		So(waitFor(func() bool { return MainCron.Executor().Stats().Running == 0 }), ShouldBeTrue)
+		So(len(waiting.started), ShouldEqual, 0)
+	})
+
+	Convey("Callbacks are limited by the host they call.", t, func() {
+		So(beanJob(MainCron, Bean{Url: "http://127.0.0.1:8080/cb"}).Host(), ShouldEqual, "127.0.0.1:8080")
+	})
+
+	Convey("The stats are served over HTTP.", t, func() {
+		w := httptest.NewRecorder()
+		statsHandler(w, httptest.NewRequest("GET", "/stats", nil))
+		var res struct {
+			Ret  int
+			Data ExecutorStats
+		}
+		So(json.Unmarshal(w.Body.Bytes(), &res), ShouldBeNil)
+		So(res.Ret, ShouldEqual, 1)
+		So(res.Data.MaxInFlight, ShouldEqual, 1)
+
+		w = httptest.NewRecorder()
+		statsHandler(w, httptest.NewRequest("POST", "/stats", nil))
+		So(w.Code, ShouldEqual, http.StatusMethodNotAllowed)
+	})
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"container/list"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// Execution is the record of one run of a job.
+type Execution struct {
+	Job     int64
+	Attempt int `json:",omitempty"`
+
+	Start   time.Time
+	End     time.Time
+	Latency time.Duration
+
+	// The response status and the start of its body, and what went wrong.
+	Status int    `json:",omitempty"`
+	Error  string `json:",omitempty"`
+	Body   string `json:",omitempty"`
+
+	// Set for a run left out under the Overlap of the job, saying why.
+	Skipped bool `json:",omitempty"`
+
+	// Set on the record written as the job is deleted, before which its
+	// executions are not read back from the file.
+	Deleted bool `json:",omitempty"`
+}
+
+// DefaultHistorySize is how many executions are kept in memory for each job.
+const DefaultHistorySize = 50
+
+// DefaultHistoryJobs is how many jobs executions are kept in memory for.
+const DefaultHistoryJobs = 10000
+
+// History keeps the latest executions of the jobs that ran last in memory, in
+// a ring of a fixed size per job, for a fixed number of jobs, and all of them
+// in an append-only file.
+type History struct {
+	mu   sync.Mutex
+	size int
+	jobs int
+	runs map[int64]*ring
+	log  *Logbk
+
+	// The ids of the jobs kept, the one that ran last in front.
+	order *list.List
+}
+
+// ring holds the last executions of one job.
+type ring struct {
+	runs []Execution
+	next int
+
+	// The place of the job in History.order.
+	elem *list.Element
+}
+
+func (r *ring) add(e Execution, size int) {
+	if len(r.runs) < size {
+		r.runs = append(r.runs, e)
+		return
+	}
+	r.runs[r.next] = e
+	r.next = (r.next + 1) % size
+}
+
+// list returns the executions oldest first.
+func (r *ring) list() []Execution {
+	runs := make([]Execution, 0, len(r.runs))
+	runs = append(runs, r.runs[r.next:]...)
+	return append(runs, r.runs[:r.next]...)
+}
+
+// NewHistory opens the history file, filling the rings from what it holds,
+// leaving out the jobs deleted since. It keeps size executions of each of
+// the last jobs that ran, or the defaults if size or jobs is not positive.
+func NewHistory(filename string, size, jobs int) (*History, error) {
+	if size <= 0 {
+		size = DefaultHistorySize
+	}
+	if jobs <= 0 {
+		jobs = DefaultHistoryJobs
+	}
+	h := &History{
+		size:  size,
+		jobs:  jobs,
+		runs:  make(map[int64]*ring),
+		order: list.New(),
+	}
+	err := ReadJson(filename, func(data []byte) error {
+		var e Execution
+		if err := json.Unmarshal(data, &e); err != nil {
+			return err
+		}
+		h.add(e)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if h.log, err = Newbk(filename); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// add keeps e in the ring of its job, or drops the ring of a deleted job,
+// making room for a new ring by dropping that of the job that ran least
+// recently.
+func (h *History) add(e Execution) {
+	if e.Deleted {
+		h.forget(e.Job)
+		return
+	}
+	r, ok := h.runs[e.Job]
+	if ok {
+		h.order.MoveToFront(r.elem)
+	} else {
+		if h.order.Len() >= h.jobs {
+			h.forget(h.order.Back().Value.(int64))
+		}
+		r = &ring{elem: h.order.PushFront(e.Job)}
+		h.runs[e.Job] = r
+	}
+	r.add(e, h.size)
+}
+
+func (h *History) forget(job int64) {
+	if r, ok := h.runs[job]; ok {
+		h.order.Remove(r.elem)
+		delete(h.runs, job)
+	}
+}
+
+// Record adds an execution.
+func (h *History) Record(e Execution) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.add(e)
+	h.log.WriteJson(e)
+}
+
+// Runs returns the executions kept in memory for a job, oldest first.
+func (h *History) Runs(job int64) []Execution {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if r, ok := h.runs[job]; ok {
+		return r.list()
+	}
+	return []Execution{}
+}
+
+// Forget drops the executions kept in memory for a deleted job. They stay in
+// the file, but are not read back from it.
+func (h *History) Forget(job int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	e := Execution{Job: job, End: time.Now(), Deleted: true}
+	h.add(e)
+	h.log.WriteJson(e)
+}
+
+// Close closes the history file.
+func (h *History) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.log.Close()
+}
+
+// recordRun adds an execution to the history, if one is kept.
+func recordRun(e Execution) {
+	if history != nil {
+		history.Record(e)
+	}
+}
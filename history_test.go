@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestHistory(t *testing.T) {
+	f, _ := ioutil.TempFile("", "runs")
+	f.Close()
+	defer os.Remove(f.Name())
+
+	h, err := NewHistory(f.Name(), 3, 0)
+	Convey("Only the last executions of a job are kept in memory.", t, func() {
+		So(err, ShouldBeNil)
+		for i := 1; i <= 5; i++ {
+			h.Record(Execution{Job: 1, Attempt: i})
+		}
+		h.Record(Execution{Job: 2, Attempt: 1})
+
+		runs := h.Runs(1)
+		So(len(runs), ShouldEqual, 3)
+		So(runs[0].Attempt, ShouldEqual, 3)
+		So(runs[2].Attempt, ShouldEqual, 5)
+		So(len(h.Runs(2)), ShouldEqual, 1)
+		So(h.Runs(3), ShouldBeEmpty)
+	})
+
+	Convey("The rings are filled again from the file.", t, func() {
+		h.Close()
+		h, err := NewHistory(f.Name(), 2, 0)
+		So(err, ShouldBeNil)
+		runs := h.Runs(1)
+		So(len(runs), ShouldEqual, 2)
+		So(runs[1].Attempt, ShouldEqual, 5)
+
+		h.Forget(1)
+		So(h.Runs(1), ShouldBeEmpty)
+		h.Close()
+	})
+
+	Convey("Deleted jobs are not read back from the file.", t, func() {
+		h, err := NewHistory(f.Name(), 2, 0)
+		So(err, ShouldBeNil)
+		defer h.Close()
+		So(h.Runs(1), ShouldBeEmpty)
+		So(len(h.Runs(2)), ShouldEqual, 1)
+	})
+
+	Convey("Only the jobs that ran last are kept in memory.", t, func() {
+		h, err := NewHistory(f.Name()+".jobs", 2, 3)
+		So(err, ShouldBeNil)
+		defer os.Remove(f.Name() + ".jobs")
+		defer h.Close()
+		for job := int64(1); job <= 4; job++ {
+			h.Record(Execution{Job: job})
+		}
+		h.Record(Execution{Job: 2})
+		h.Record(Execution{Job: 5})
+		So(h.Runs(1), ShouldBeEmpty)
+		So(h.Runs(3), ShouldBeEmpty)
+		So(len(h.Runs(2)), ShouldEqual, 2)
+		So(len(h.Runs(4)), ShouldEqual, 1)
+		So(len(h.Runs(5)), ShouldEqual, 1)
+	})
+}
+
+func TestRunsHandler(t *testing.T) {
+	defer testMain()()
+	MainCron.Start()
+	defer MainCron.Stop()
+
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/bad" {
+			w.WriteHeader(http.StatusTeapot)
+		}
+		w.Write([]byte(strings.Repeat("x", 2*SnippetSize)))
+	}))
+	defer target.Close()
+
+	Convey("Every run of a job is recorded with its outcome.", t, func() {
+		id := MainCron.AddNowjob(beanJob(MainCron, Bean{Method: "now", Url: target.URL}))
+		bad := MainCron.AddNowjob(beanJob(MainCron, Bean{Method: "now", Url: target.URL + "/bad"}))
+		time.Sleep(100 * time.Millisecond)
+
+		var res struct {
+			Ret  int
+			Data []Execution
+		}
+		w := httptest.NewRecorder()
+		jobHandler(w, httptest.NewRequest("GET", "/jobs/"+strconv.FormatInt(id, 10)+"/runs", nil))
+		So(json.Unmarshal(w.Body.Bytes(), &res), ShouldBeNil)
+		So(len(res.Data), ShouldEqual, 1)
+		run := res.Data[0]
+		So(run.Job, ShouldEqual, id)
+		So(run.Status, ShouldEqual, http.StatusOK)
+		So(run.Error, ShouldBeEmpty)
+		So(len(run.Body), ShouldEqual, SnippetSize)
+		So(run.Latency, ShouldBeGreaterThan, 0)
+		So(run.End.Before(run.Start), ShouldBeFalse)
+
+		runs := history.Runs(bad)
+		So(len(runs), ShouldEqual, 1)
+		So(runs[0].Status, ShouldEqual, http.StatusTeapot)
+		So(runs[0].Error, ShouldNotBeEmpty)
+	})
+}
@@ -1,14 +1,22 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"net/url"
-	"time"
-	//	"strconv"
+	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
+	"unicode"
+
+	"github.com/ghzofhit/job/config"
 )
 
 type Result struct {
@@ -18,15 +26,31 @@ type Result struct {
 }
 
 var (
-	MainCron *Cron
-	databk   *Logbk
-	logs     *Logbk
+	MainCron    *Cron
+	databk      *Logbk
+	logs        *Logbk
+	deadletters *DeadLetters
+	history     *History
+	nsqd        *Nsq
 )
 
 func main() {
-	MainCron = New()
-	MainCron.Start()
-	var err error
+	cfg := config.New()
+	if err := cfg.Load(os.Args[1:]); err != nil {
+		fmt.Println("配置错误:", err)
+		return
+	}
+	if cfg.NsqdAddress != "" {
+		nsqd = NewNsq(cfg.NsqdAddress, cfg.NsqTopic)
+	}
+
+	MainCron = New(WithExecutor(NewExecutor(cfg.MaxInFlight, cfg.MaxPerHost)))
+	restored, err := Replay("data.log", MainCron, RecoverSkip)
+	if err != nil {
+		fmt.Println("数据日志恢复错误:", err)
+		return
+	}
+	fmt.Println("恢复任务数:", restored)
 	databk, err = Newbk("data.log")
 	if err != nil {
 		fmt.Println("数据日志创建错误")
@@ -37,12 +61,88 @@ func main() {
 		fmt.Println("日志创建错误")
 		return
 	}
+	deadletters, err = NewDeadLetters("deadletter.log")
+	if err != nil {
+		fmt.Println("死信日志创建错误:", err)
+		return
+	}
+	history, err = NewHistory("runs.log", DefaultHistorySize, DefaultHistoryJobs)
+	if err != nil {
+		fmt.Println("执行历史创建错误:", err)
+		return
+	}
+	// Jobs write to the logs as they run.
+	MainCron.Start()
+	var consumer *Consumer
+	if cfg.NsqLookupdAddress != "" {
+		consumer = NewConsumer(cfg.NsqConsumeTopic, cfg.NsqChannel,
+			strings.Split(cfg.NsqLookupdAddress, ","), beanHandler(MainCron))
+		if err := consumer.Start(); err != nil {
+			fmt.Println("nsq消费者启动错误:", err)
+			return
+		}
+	}
 	//*
 	http.HandleFunc("/add/cron/", cronHandler)
 	http.HandleFunc("/add/now/", nowHandler)
 	http.HandleFunc("/add/once/", onceHandler)
-	http.ListenAndServe(":8888", nil)
+	http.HandleFunc("/jobs", jobsHandler)
+	http.HandleFunc("/jobs/", jobHandler)
+	http.HandleFunc("/deadletters", deadLettersHandler)
+	http.HandleFunc("/deadletters/", deadLettersHandler)
+	http.HandleFunc("/stats", statsHandler)
+	server := &http.Server{Addr: ":8888"}
+	served := make(chan error, 1)
+	go func() {
+		served <- server.ListenAndServe()
+	}()
 	// */
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case sig := <-signals:
+		fmt.Println("收到信号:", sig)
+	case err := <-served:
+		fmt.Println("服务错误:", err)
+	}
+	shutdown(server, consumer)
+}
+
+// ShutdownTimeout bounds how long shutting down waits for requests being
+// served and jobs running.
+const ShutdownTimeout = DefaultTimeout + 5*time.Second
+
+// shutdown stops taking jobs in, first from nsq and then over HTTP, lets the
+// jobs running finish and closes the logs they write to.
+func shutdown(server *http.Server, consumer *Consumer) {
+	ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+	if consumer != nil {
+		consumer.Stop()
+	}
+	if err := server.Shutdown(ctx); err != nil {
+		fmt.Println("服务关闭错误:", err)
+	}
+	if err := MainCron.Shutdown(ctx); err != nil {
+		fmt.Println("任务未能全部完成:", err)
+	}
+	closeLogs()
+}
+
+// closeLogs closes every log file that is open.
+func closeLogs() {
+	for _, l := range []*Logbk{databk, logs} {
+		if l != nil {
+			l.Close()
+		}
+	}
+	if deadletters != nil {
+		deadletters.Close()
+	}
+	if history != nil {
+		history.Close()
+	}
 }
 
 //*
@@ -53,44 +153,442 @@ func cronHandler(w http.ResponseWriter, r *http.Request) {
 		OutputJson(w, 0, "参数错误", nil)
 		return
 	}
-	feed := r.FormValue("url")
-	schedule := r.FormValue("schedule")
+	var bean Bean
+	if !readTarget(w, r, &bean) {
+		return
+	}
+	if bean.Overlap, err = ParseOverlap(r.FormValue("overlap")); err != nil {
+		OutputJson(w, 0, "overlap错误: "+err.Error(), nil)
+		return
+	}
+	if bean.Misfire, err = ParseMisfire(r.FormValue("misfire")); err != nil {
+		OutputJson(w, 0, "misfire错误: "+err.Error(), nil)
+		return
+	}
 
-	if !strings.HasPrefix(feed, "http") {
-		feed = "http://" + feed
+	bean.Method, bean.Schedule, bean.Tz = "cron", r.FormValue("schedule"), r.FormValue("tz")
+	bean, next, err := addBean(MainCron, bean)
+	if err != nil {
+		addFailed(w, err)
+		return
+	}
+
+	OutputJson(w, 1, "ok", map[string]interface{}{"Id": bean.Id, "Next": next})
+}
+
+func nowHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "application/json")
+	err := r.ParseForm()
+	if err != nil {
+		OutputJson(w, 0, "参数错误", nil)
+		return
+	}
+	var bean Bean
+	if !readTarget(w, r, &bean) {
+		return
+	}
+	sync := r.FormValue("sync") == "1" || r.FormValue("sync") == "true"
+
+	bean.Method = "now"
+	schedule, _, err := checkBean(&bean, time.Now())
+	if err != nil {
+		addFailed(w, err)
+		return
+	}
+	job := beanJob(MainCron, bean)
+	if sync {
+		job.notify = make(chan error, 1)
+	}
+	bean.Id = MainCron.Schedule(schedule, job)
+	journal(bean)
+
+	data := map[string]interface{}{"Id": bean.Id}
+	if !sync {
+		OutputJson(w, 1, "ok", data)
+		return
+	}
+	select {
+	case err = <-job.notify:
+	case <-r.Context().Done():
+		return
+	}
+	if err != nil {
+		data["Error"] = err.Error()
+		OutputJson(w, 0, "回调失败", data)
+		return
+	}
+	OutputJson(w, 1, "ok", data)
+}
+
+func onceHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "application/json")
+	err := r.ParseForm()
+	if err != nil {
+		OutputJson(w, 0, "参数错误", nil)
+		return
+	}
+	var bean Bean
+	if !readTarget(w, r, &bean) {
+		return
 	}
 
-	//判断url是否合理
-	host, err := url.ParseRequestURI(feed)
+	bean.Method, bean.Schedule, bean.Delay, bean.Tz = "once", r.FormValue("time"), r.FormValue("delay"), r.FormValue("tz")
+	bean, next, err := addBean(MainCron, bean)
 	if err != nil {
+		addFailed(w, err)
+		return
+	}
+
+	OutputJson(w, 1, "ok", map[string]interface{}{"Id": bean.Id, "Next": next})
+}
+
+// readTarget fills in what the job of an add request does when it fires,
+// calling a url or, with target=nsq, publishing to nsq, and how that is
+// retried, for checkBean to check. It answers the request itself if
+// something cannot be read.
+func readTarget(w http.ResponseWriter, r *http.Request, bean *Bean) bool {
+	var err error
+	if r.FormValue("target") == "nsq" {
+		bean.Nsq = parsePublish(r)
+	} else {
+		bean.Url = r.FormValue("url")
+		if bean.Callback, err = parseCallback(r); err != nil {
+			OutputJson(w, 0, "回调参数错误: "+err.Error(), nil)
+			return false
+		}
+	}
+	if bean.Retry, err = parseRetry(r); err != nil {
+		OutputJson(w, 0, "重试参数错误: "+err.Error(), nil)
+		return false
+	}
+	return true
+}
+
+// AddError is what is wrong with an add request, made over HTTP or read off
+// nsq, and in which of its parameters.
+type AddError struct {
+	Param string
+	Err   error
+}
+
+func (e *AddError) Error() string {
+	return e.Param + ": " + e.Err.Error()
+}
+
+func (e *AddError) Unwrap() error {
+	return e.Err
+}
+
+// What is wrong with the fire time of an add request that is not an error
+// of its own.
+var (
+	errPassed     = errors.New("time has passed")
+	errNeverFires = errors.New("schedule never fires")
+)
+
+// addReasons are how the parameters of an AddError are reported over HTTP.
+var addReasons = map[string]string{
+	"url":      "url错误",
+	"nsq":      "nsq参数错误",
+	"callback": "回调参数错误",
+	"retry":    "重试参数错误",
+	"tz":       "tz错误",
+	"schedule": "schedule错误",
+	"time":     "时间错误",
+	"method":   "参数错误",
+}
 
+// addFailed answers an add request turned down by checkBean.
+func addFailed(w http.ResponseWriter, err error) {
+	e, ok := err.(*AddError)
+	switch {
+	case !ok:
+		OutputJson(w, 0, "参数错误", nil)
+	case e.Err == errPassed:
+		OutputJson(w, 0, "时间已过", nil)
+	case e.Err == errNeverFires:
+		OutputJson(w, 0, "schedule不会触发", nil)
+	default:
+		OutputJson(w, 0, addReasons[e.Param]+": "+e.Err.Error(), nil)
 	}
+}
 
-	//判断是否能解析到对应的host记录
-	_, err = net.LookupIP(host.Host)
+// addBean checks an add request, made over HTTP or read off nsq, then adds
+// its job to c and journals it. It returns the journaled bean and the next
+// time the job fires.
+func addBean(c *Cron, bean Bean) (Bean, time.Time, error) {
+	schedule, next, err := checkBean(&bean, time.Now())
 	if err != nil {
+		return Bean{}, time.Time{}, err
+	}
+	bean.Id = c.Schedule(schedule, beanJob(c, bean), WithOverlap(bean.Overlap), WithMisfire(bean.Misfire))
+	journal(bean)
+	return bean, next, nil
+}
 
+// checkBean checks an add request, made over HTTP or read off nsq, and puts
+// it in the form it is journaled in. Method says which kind of job it is,
+// and Schedule when it fires: a spec for cron, read in Tz if given; a time
+// for once, given as RFC3339 or a Unix timestamp, or without an offset in
+// Tz, or else a Delay from now; and nothing for now. It returns the Schedule
+// of the job and when it first fires, or an *AddError.
+func checkBean(bean *Bean, now time.Time) (Schedule, time.Time, error) {
+	if err := checkTarget(bean); err != nil {
+		return nil, time.Time{}, err
 	}
+	var (
+		schedule Schedule
+		next     time.Time
+		err      error
+	)
+	switch bean.Method {
+	case "cron":
+		spec, err := zonedSpec(strings.TrimSpace(bean.Schedule), strings.TrimSpace(bean.Tz))
+		if err != nil {
+			return nil, time.Time{}, &AddError{"tz", err}
+		}
+		if schedule, err = ParseSpec(spec); err != nil {
+			return nil, time.Time{}, &AddError{"schedule", err}
+		}
+		if next = schedule.Next(now); next.IsZero() {
+			return nil, time.Time{}, &AddError{"schedule", errNeverFires}
+		}
+		bean.Schedule = spec
+	case "once":
+		if next, err = onceTime(bean.Schedule, bean.Delay, strings.TrimSpace(bean.Tz), now); err != nil {
+			return nil, time.Time{}, &AddError{"time", err}
+		}
+		if !next.After(now) {
+			return nil, time.Time{}, &AddError{"time", errPassed}
+		}
+		schedule, bean.Schedule = &OnceSchedule{thetime: next}, next.Format(onceLayout)
+	case "now":
+		schedule, next, bean.Schedule = &nowSchedule{}, now, now.Format(onceLayout)
+	default:
+		return nil, time.Time{}, &AddError{"method", fmt.Errorf("unknown method %q", bean.Method)}
+	}
+	bean.Time, bean.Delay, bean.Tz = now, "", ""
+	bean.Attempt, bean.Status, bean.Error = 0, 0, ""
+	return schedule, next, nil
+}
 
-	//	time := r.FormValue("time")
-	var jid int64
-	jid = MainCron.AddFunc("@hourly", func(id int64) { CallUrl(feed, id) })
+// checkTarget checks what the job of bean does when it fires, and how that
+// is retried, putting its url and topic in their usual form.
+func checkTarget(bean *Bean) error {
+	var err error
+	if bean.Nsq != nil {
+		if bean.Url != "" || bean.Callback != nil {
+			return &AddError{"nsq", errors.New("url and nsq are exclusive")}
+		}
+		if nsqd == nil {
+			return &AddError{"nsq", errors.New("nsqd is not configured")}
+		}
+		if bean.Nsq.Topic == "" {
+			bean.Nsq.Topic = nsqd.Topic
+		}
+		if err = bean.Nsq.check(); err != nil {
+			return &AddError{"nsq", err}
+		}
+	} else {
+		if bean.Url, err = checkUrl(bean.Url); err != nil {
+			return &AddError{"url", err}
+		}
+		if bean.Callback != nil {
+			bean.Callback.Method = strings.ToUpper(strings.TrimSpace(bean.Callback.Method))
+			if err = bean.Callback.check(); err != nil {
+				return &AddError{"callback", err}
+			}
+		}
+	}
+	if bean.Retry != nil {
+		if err = bean.Retry.check(); err != nil {
+			return &AddError{"retry", err}
+		}
+	}
+	return nil
+}
 
-	databk.WriteBin(Bean{Id: jid,
-		Time:     time.Now(),
-		Schedule: schedule,
-		Method:   "cron",
-		Url:      "feed"})
+// checkUrl normalizes the callback url of an add request and makes sure its
+// host resolves.
+func checkUrl(feed string) (string, error) {
+	if feed == "" {
+		return "", errors.New("missing url")
+	}
+	if !strings.HasPrefix(feed, "http") {
+		feed = "http://" + feed
+	}
+	u, err := url.ParseRequestURI(feed)
+	if err != nil {
+		return "", err
+	}
+	if u.Host == "" {
+		return "", errors.New("missing host")
+	}
+	if _, err := net.LookupIP(u.Hostname()); err != nil {
+		return "", err
+	}
+	return feed, nil
+}
 
-	return
+// onceTime reads the fire time of a once request. It is either an absolute
+// time at, given as RFC3339 or a Unix timestamp, or a delay from now such as
+// "90s". With a tz, the time may also be given without an offset, as
+// "2006-01-02T15:04:05" on the clock of that time zone.
+func onceTime(at, delay, tz string, now time.Time) (time.Time, error) {
+	switch {
+	case at != "" && delay != "":
+		return time.Time{}, errors.New("time and delay are exclusive")
+	case delay != "":
+		d, err := time.ParseDuration(delay)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return now.Add(d), nil
+	case at != "" && tz != "":
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if t, err := time.ParseInLocation(wallLayout, at, loc); err == nil {
+			return t, nil
+		}
+		return parseTime(at)
+	case at != "":
+		return parseTime(at)
+	}
+	return time.Time{}, errors.New("missing time or delay")
 }
 
-func nowHandler(w http.ResponseWriter, r *http.Request) {
+// callMethods are the HTTP methods a callback may use.
+var callMethods = map[string]bool{
+	"GET": true, "POST": true, "PUT": true, "PATCH": true, "DELETE": true, "HEAD": true,
+}
 
+// parseCallback reads how the url of an add request is to be called:
+//
+//	call_method - the HTTP method, GET by default
+//	header      - "Name: value", may be repeated
+//	body        - the request body, sent as JSON unless a Content-Type header is given
+//	timeout     - such as "5s", DefaultTimeout by default
+//
+// It returns nil if none of them is set.
+func parseCallback(r *http.Request) (*Callback, error) {
+	cb := &Callback{
+		Method: strings.ToUpper(strings.TrimSpace(r.FormValue("call_method"))),
+		Body:   r.FormValue("body"),
+	}
+	for _, h := range r.Form["header"] {
+		i := strings.Index(h, ":")
+		if i <= 0 {
+			return nil, errors.New("malformed header " + h)
+		}
+		if cb.Header == nil {
+			cb.Header = make(map[string]string)
+		}
+		cb.Header[http.CanonicalHeaderKey(strings.TrimSpace(h[:i]))] = strings.TrimSpace(h[i+1:])
+	}
+	if v := r.FormValue("timeout"); v != "" {
+		timeout, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, err
+		}
+		if timeout <= 0 {
+			return nil, errors.New("timeout should be positive")
+		}
+		cb.Timeout = timeout
+	}
+	if cb.Method == "" && cb.Header == nil && cb.Body == "" && cb.Timeout == 0 {
+		return nil, nil
+	}
+	return cb, nil
 }
 
-func onceHandler(w http.ResponseWriter, r *http.Request) {
+// parseRetry reads how a failed callback of an add request is retried:
+//
+//	retry        - the most attempts, the first one included
+//	backoff      - the delay before the first retry, such as "10s"
+//	multiplier   - how much longer each later retry waits, 2 by default
+//	jitter       - the fraction by which delays are randomly moved, 0 to 1
+//	retry_status - comma separated response codes worth retrying
+//
+// It returns nil if none of them is set.
+func parseRetry(r *http.Request) (*Retry, error) {
+	var (
+		retry = &Retry{}
+		set   = false
+		err   error
+	)
+	if v := r.FormValue("retry"); v != "" {
+		if retry.Attempts, err = strconv.Atoi(v); err != nil {
+			return nil, err
+		}
+		set = true
+	}
+	if v := r.FormValue("backoff"); v != "" {
+		if retry.Backoff, err = time.ParseDuration(v); err != nil {
+			return nil, err
+		}
+		set = true
+	}
+	if v := r.FormValue("multiplier"); v != "" {
+		if retry.Multiplier, err = strconv.ParseFloat(v, 64); err != nil {
+			return nil, err
+		}
+		set = true
+	}
+	if v := r.FormValue("jitter"); v != "" {
+		if retry.Jitter, err = strconv.ParseFloat(v, 64); err != nil {
+			return nil, err
+		}
+		set = true
+	}
+	if v := r.FormValue("retry_status"); v != "" {
+		for _, code := range strings.Split(v, ",") {
+			n, err := strconv.Atoi(strings.TrimSpace(code))
+			if err != nil {
+				return nil, err
+			}
+			retry.Status = append(retry.Status, n)
+		}
+		set = true
+	}
+	if !set {
+		return nil, nil
+	}
+	if r.FormValue("retry") == "" {
+		return nil, errors.New("missing retry")
+	}
+	return retry, nil
+}
+
+// wallLayout is how the time of a once request is read on the clock of its
+// "tz".
+const wallLayout = "2006-01-02T15:04:05"
+
+// zonedSpec has the schedule spec of a request read in the time zone tz,
+// which takes the place of any CRON_TZ= the spec starts with. It returns spec
+// as it is when tz is empty.
+func zonedSpec(spec, tz string) (string, error) {
+	if tz == "" {
+		return spec, nil
+	}
+	if _, err := time.LoadLocation(tz); err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(spec, "TZ=") || strings.HasPrefix(spec, "CRON_TZ=") {
+		if i := strings.IndexFunc(spec, unicode.IsSpace); i > 0 {
+			spec = strings.TrimSpace(spec[i:])
+		}
+	}
+	return "CRON_TZ=" + tz + " " + spec, nil
+}
 
+// parseTime reads a time given either as RFC3339 or as a Unix timestamp.
+func parseTime(v string) (time.Time, error) {
+	if sec, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	return time.Parse(time.RFC3339, v)
 }
 
 func ajaxHandler(w http.ResponseWriter, r *http.Request) {
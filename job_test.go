@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testMain points the globals used by the handlers at a fresh, stopped Cron
+// and temporary journal, dead letter and history files. The returned func
+// shuts the Cron down and removes the files.
+func testMain() func() {
+	MainCron = New()
+	f, err := ioutil.TempFile("", "data")
+	if err != nil {
+		panic(err)
+	}
+	f.Close()
+	databk, err = Newbk(f.Name())
+	if err != nil {
+		panic(err)
+	}
+	deadletters, err = NewDeadLetters(f.Name() + ".dead")
+	if err != nil {
+		panic(err)
+	}
+	history, err = NewHistory(f.Name()+".runs", 3, 0)
+	if err != nil {
+		panic(err)
+	}
+	return func() {
+		// Let the jobs still running finish before the files go.
+		ctx, cancel := context.WithTimeout(context.Background(), ONE_SECOND)
+		defer cancel()
+		MainCron.Shutdown(ctx)
+		databk.logfile.Close()
+		deadletters.Close()
+		history.Close()
+		os.Remove(f.Name())
+		os.Remove(f.Name() + ".dead")
+		os.Remove(f.Name() + ".runs")
+	}
+}
+
+func post(handler http.HandlerFunc, path string, form url.Values) Result {
+	r := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	handler(w, r)
+	var res Result
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		panic(err)
+	}
+	return res
+}
+
+func TestOnceHandler(t *testing.T) {
+	defer testMain()()
+
+	Convey("A once job can be added with a delay, a Unix time or RFC3339.", t, func() {
+		for _, form := range []url.Values{
+			{"url": {"http://127.0.0.1/cb"}, "delay": {"90s"}},
+			{"url": {"http://127.0.0.1/cb"}, "time": {strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)}},
+			{"url": {"http://127.0.0.1/cb"}, "time": {time.Now().Add(time.Hour).Format(time.RFC3339)}},
+		} {
+			res := post(onceHandler, "/add/once/", form)
+			So(res.Ret, ShouldEqual, 1)
+			So(res.Data.(map[string]interface{})["Id"], ShouldNotBeZeroValue)
+		}
+		So(len(MainCron.Entries()), ShouldEqual, 3)
+	})
+
+	Convey("Invalid once requests are rejected.", t, func() {
+		for _, form := range []url.Values{
+			{"delay": {"90s"}},
+			{"url": {"http://127.0.0.1/cb"}},
+			{"url": {"http://127.0.0.1/cb"}, "delay": {"soon"}},
+			{"url": {"http://127.0.0.1/cb"}, "delay": {"-1m"}},
+			{"url": {"http://127.0.0.1/cb"}, "delay": {"1m"}, "time": {"1"}},
+		} {
+			So(post(onceHandler, "/add/once/", form).Ret, ShouldEqual, 0)
+		}
+	})
+
+	Convey("Once jobs are journaled.", t, func() {
+		cron := New()
+		n, err := Replay(databk.logfile.Name(), cron, RecoverSkip)
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, 3)
+	})
+}
+
+func TestNowHandler(t *testing.T) {
+	defer testMain()()
+	MainCron.Start()
+	defer MainCron.Stop()
+
+	called := make(chan bool, 1)
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called <- true
+	}))
+	defer target.Close()
+
+	Convey("A now job fires right away.", t, func() {
+		res := post(nowHandler, "/add/now/", url.Values{"url": {target.URL}})
+		So(res.Ret, ShouldEqual, 1)
+		tag := false
+		select {
+		case <-time.After(ONE_SECOND):
+		case tag = <-called:
+		}
+		So(tag, ShouldBeTrue)
+	})
+
+	Convey("In sync mode the outcome of the first attempt is returned.", t, func() {
+		res := post(nowHandler, "/add/now/", url.Values{"url": {target.URL}, "sync": {"1"}})
+		So(res.Ret, ShouldEqual, 1)
+		<-called
+
+		dead := httptest.NewServer(http.NotFoundHandler())
+		dead.Close()
+		res = post(nowHandler, "/add/now/", url.Values{"url": {dead.URL}, "sync": {"1"}})
+		So(res.Ret, ShouldEqual, 0)
+		So(res.Data.(map[string]interface{})["Error"], ShouldNotBeEmpty)
+	})
+}
+
+func TestCronHandler(t *testing.T) {
+	defer testMain()()
+
+	Convey("A cron job runs on the submitted schedule.", t, func() {
+		for _, spec := range []string{"0 30 * * * ?", "0 30 * * *", "@daily", "@every 90s"} {
+			res := post(cronHandler, "/add/cron/", url.Values{"url": {"127.0.0.1/cb"}, "schedule": {spec}})
+			So(res.Ret, ShouldEqual, 1)
+			next, err := time.Parse(time.RFC3339, res.Data.(map[string]interface{})["Next"].(string))
+			So(err, ShouldBeNil)
+			So(next.After(time.Now()), ShouldBeTrue)
+		}
+	})
+
+	Convey("The real url and spec are journaled.", t, func() {
+		cron := New()
+		n, err := Replay(databk.logfile.Name(), cron, RecoverSkip)
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, 4)
+		So(cron.entries[0].Job.(*CallJob).Bean.Url, ShouldEqual, "http://127.0.0.1/cb")
+	})
+
+	Convey("Bad specs are rejected.", t, func() {
+		for _, spec := range []string{"", "* * *", "0 0 0 30 Feb ?", "@sometimes", "CRON_TZ=Mars/Olympus @daily"} {
+			res := post(cronHandler, "/add/cron/", url.Values{"url": {"127.0.0.1/cb"}, "schedule": {spec}})
+			So(res.Ret, ShouldEqual, 0)
+		}
+		res := post(cronHandler, "/add/cron/", url.Values{"url": {"127.0.0.1/cb"}, "schedule": {"@daily"}, "tz": {"Mars/Olympus"}})
+		So(res.Ret, ShouldEqual, 0)
+	})
+}
+
+func TestZoneHandler(t *testing.T) {
+	defer testMain()()
+	shanghai, _ := time.LoadLocation("Asia/Shanghai")
+
+	Convey("Cron jobs are read in the time zone of the tz param.", t, func() {
+		res := post(cronHandler, "/add/cron/", url.Values{"url": {"127.0.0.1/cb"}, "schedule": {"0 0 9 * * *"}, "tz": {"Asia/Shanghai"}})
+		So(res.Ret, ShouldEqual, 1)
+		next, _ := time.Parse(time.RFC3339, res.Data.(map[string]interface{})["Next"].(string))
+		So(next.In(shanghai).Hour(), ShouldEqual, 9)
+
+		// The tz param takes the place of the zone in the spec.
+		res = post(cronHandler, "/add/cron/", url.Values{"url": {"127.0.0.1/cb"}, "schedule": {"CRON_TZ=UTC 0 0 9 * * *"}, "tz": {"Asia/Shanghai"}})
+		So(res.Ret, ShouldEqual, 1)
+
+		_, jobs := getJobs(nil)
+		So(jobs[0].Schedule, ShouldEqual, "CRON_TZ=Asia/Shanghai 0 0 9 * * *")
+		So(jobs[1].Schedule, ShouldEqual, "CRON_TZ=Asia/Shanghai 0 0 9 * * *")
+
+		// So is the zone of an update, with or without a new schedule.
+		path := "/jobs/" + strconv.FormatInt(jobs[0].Id, 10)
+		code, _ := putJob(path, url.Values{"tz": {"UTC"}})
+		So(code, ShouldEqual, http.StatusOK)
+		_, jobs = getJobs(nil)
+		So(jobs[0].Schedule, ShouldEqual, "CRON_TZ=UTC 0 0 9 * * *")
+		So(jobs[0].Next.UTC().Hour(), ShouldEqual, 9)
+	})
+
+	Convey("Once jobs may be given on the clock of the tz param.", t, func() {
+		at := time.Now().In(shanghai).Add(time.Hour)
+		res := post(onceHandler, "/add/once/", url.Values{"url": {"127.0.0.1/cb"}, "time": {at.Format(wallLayout)}, "tz": {"Asia/Shanghai"}})
+		So(res.Ret, ShouldEqual, 1)
+		next, _ := time.Parse(time.RFC3339, res.Data.(map[string]interface{})["Next"].(string))
+		So(next.Unix(), ShouldEqual, at.Unix())
+	})
+
+	Convey("The zone survives a restart.", t, func() {
+		cron := New()
+		_, err := Replay(databk.logfile.Name(), cron, RecoverSkip)
+		So(err, ShouldBeNil)
+		So(cron.entries[0].Schedule.(*SpecSchedule).Location.String(), ShouldEqual, "UTC")
+	})
+}
+
+func TestCallbackForm(t *testing.T) {
+	defer testMain()()
+	MainCron.Start()
+	defer MainCron.Stop()
+
+	got := make(chan *http.Request, 1)
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		got <- r
+	}))
+	defer target.Close()
+
+	Convey("The add requests take the callback spec.", t, func() {
+		res := post(nowHandler, "/add/now/", url.Values{
+			"url":         {target.URL},
+			"call_method": {"put"},
+			"header":      {"X-Token: abc", "content-type: application/x-www-form-urlencoded"},
+			"body":        {"a=1"},
+			"timeout":     {"5s"},
+		})
+		So(res.Ret, ShouldEqual, 1)
+		r := <-got
+		So(r.Method, ShouldEqual, "PUT")
+		So(r.Header.Get("X-Token"), ShouldEqual, "abc")
+		So(r.Form.Get("a"), ShouldEqual, "1")
+	})
+
+	Convey("Bad callback and retry specs are rejected.", t, func() {
+		for _, form := range []url.Values{
+			{"call_method": {"FETCH"}},
+			{"header": {"no colon"}},
+			{"timeout": {"-5s"}},
+			{"retry": {"0"}},
+			{"retry": {"3"}, "jitter": {"2"}},
+			{"retry": {"3"}, "retry_status": {"5xx"}},
+			{"backoff": {"10s"}},
+		} {
+			form.Set("url", target.URL)
+			So(post(nowHandler, "/add/now/", form).Ret, ShouldEqual, 0)
+		}
+	})
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// JobInfo is how a scheduled entry is reported over HTTP.
+type JobInfo struct {
+	Id       int64
+	Method   string
+	Url      string
+	Schedule string
+	Topic    string  `json:",omitempty"`
+	Overlap  Overlap `json:",omitempty"`
+	Misfire  Misfire `json:",omitempty"`
+	Origin   int64   `json:",omitempty"`
+	Prev     time.Time
+	Next     time.Time
+	Paused   bool
+}
+
+// jobInfo describes an entry. Entries that were not added through the HTTP
+// api only report their times.
+func jobInfo(e *Entry) JobInfo {
+	info := JobInfo{Id: e.Id, Prev: e.Prev, Next: e.Next, Paused: e.Paused}
+	if job, ok := e.Job.(*CallJob); ok {
+		info.Method = job.Bean.Method
+		info.Url = job.Bean.Url
+		info.Schedule = job.Bean.Schedule
+		if job.Bean.Nsq != nil {
+			info.Topic = job.Bean.Nsq.Topic
+		}
+		info.Overlap, info.Misfire, info.Origin = e.Overlap, e.Misfire, e.Origin
+		if job.attempt > 1 {
+			info.Method = "retry"
+		}
+	}
+	return info
+}
+
+// jobsHandler lists the scheduled jobs. The list may be narrowed with
+//
+//	method - only jobs added through /add/{method}/
+//	url    - only jobs whose url contains the value
+//	after, before - only jobs whose next run falls in the window, given as
+//	                RFC3339 or a Unix timestamp
+func jobsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "application/json")
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		OutputJson(w, 0, "不支持的方法", nil)
+		return
+	}
+	err := r.ParseForm()
+	if err != nil {
+		OutputJson(w, 0, "参数错误", nil)
+		return
+	}
+	method, feed := r.FormValue("method"), r.FormValue("url")
+	var after, before time.Time
+	if v := r.FormValue("after"); v != "" {
+		if after, err = parseTime(v); err != nil {
+			OutputJson(w, 0, "时间错误: "+err.Error(), nil)
+			return
+		}
+	}
+	if v := r.FormValue("before"); v != "" {
+		if before, err = parseTime(v); err != nil {
+			OutputJson(w, 0, "时间错误: "+err.Error(), nil)
+			return
+		}
+	}
+
+	jobs := []JobInfo{}
+	for _, e := range MainCron.Entries() {
+		info := jobInfo(e)
+		switch {
+		case method != "" && info.Method != method:
+		case feed != "" && !strings.Contains(info.Url, feed):
+		case !after.IsZero() && info.Next.Before(after):
+		case !before.IsZero() && (info.Next.IsZero() || info.Next.After(before)):
+		default:
+			jobs = append(jobs, info)
+		}
+	}
+	OutputJson(w, 1, "ok", jobs)
+}
+
+// jobHandler serves a single job:
+//
+//	PUT    /jobs/{id}         changes its url or schedule
+//	DELETE /jobs/{id}         removes it, and its retries still to come
+//	POST   /jobs/{id}/pause   keeps it from running
+//	POST   /jobs/{id}/resume  lets it run again
+//	GET    /jobs/{id}/runs    lists its latest executions
+//
+// Every change is journaled.
+func jobHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "application/json")
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/jobs/"), "/"), "/")
+	id, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || len(parts) > 2 {
+		w.WriteHeader(http.StatusNotFound)
+		OutputJson(w, 0, "路径错误", nil)
+		return
+	}
+	action := ""
+	if len(parts) == 2 {
+		action = parts[1]
+	}
+
+	var (
+		method string
+		change func(int64) bool
+	)
+	switch {
+	case action == "" && r.Method == "PUT":
+		updateJob(w, r, id)
+		return
+	case action == "runs" && r.Method == "GET":
+		runs := []Execution{}
+		if history != nil {
+			runs = history.Runs(id)
+		}
+		OutputJson(w, 1, "ok", runs)
+		return
+	case action == "" && r.Method == "DELETE":
+		method, change = "del", MainCron.DelJob
+	case action == "pause" && r.Method == "POST":
+		method, change = "pause", MainCron.PauseJob
+	case action == "resume" && r.Method == "POST":
+		method, change = "resume", MainCron.ResumeJob
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		OutputJson(w, 0, "不支持的方法", nil)
+		return
+	}
+
+	var entry Entry
+	if method == "del" {
+		entry, _ = MainCron.Entry(id)
+	}
+	if !change(id) {
+		w.WriteHeader(http.StatusNotFound)
+		OutputJson(w, 0, "任务不存在", nil)
+		return
+	}
+	now := time.Now()
+	databk.WriteBin(Bean{Id: id, Time: now, Method: method})
+	if job, ok := entry.Job.(*CallJob); ok && entry.Origin != 0 {
+		// A deleted retry ends the retries of its job, which the journal
+		// would otherwise put back.
+		databk.WriteBin(Bean{Id: entry.Origin, Time: now, Method: "attempt", Attempt: job.attempt, Error: ErrJobDeleted.Error()})
+	}
+	if method == "del" && history != nil {
+		history.Forget(id)
+	}
+	OutputJson(w, 1, "ok", map[string]interface{}{"Id": id})
+}
+
+// updateJob changes the url and callback, or the nsq topic and messages, the
+// retry policy, or the schedule, its time zone or the fire time, of a job
+// added through the HTTP api. The fields take the same form as for the add
+// request; a new callback, publish or retry policy replaces the old one as a
+// whole.
+func updateJob(w http.ResponseWriter, r *http.Request, id int64) {
+	err := r.ParseForm()
+	if err != nil {
+		OutputJson(w, 0, "参数错误", nil)
+		return
+	}
+	var bean Bean
+	entry, found := MainCron.Entry(id)
+	job, ok := entry.Job.(*CallJob)
+	if found && ok {
+		bean = job.Bean
+		if job.attempt > 1 {
+			bean.Method = "retry"
+		}
+	}
+	if !found || !ok {
+		w.WriteHeader(http.StatusNotFound)
+		OutputJson(w, 0, "任务不存在", nil)
+		return
+	}
+
+	now := time.Now()
+	changed := false
+	if bean.Nsq != nil {
+		if r.FormValue("topic") != "" || len(r.Form["message"]) > 0 {
+			bean.Nsq, changed = parsePublish(r), true
+		}
+	} else {
+		if v := r.FormValue("url"); v != "" {
+			bean.Url, changed = v, true
+		}
+		callback, err := parseCallback(r)
+		if err != nil {
+			OutputJson(w, 0, "回调参数错误: "+err.Error(), nil)
+			return
+		}
+		if callback != nil {
+			bean.Callback, changed = callback, true
+		}
+	}
+	retry, err := parseRetry(r)
+	if err != nil {
+		OutputJson(w, 0, "重试参数错误: "+err.Error(), nil)
+		return
+	}
+	if retry != nil {
+		bean.Retry, changed = retry, true
+	}
+	if changed {
+		if err = checkTarget(&bean); err != nil {
+			addFailed(w, err)
+			return
+		}
+	}
+	var schedule Schedule
+	switch bean.Method {
+	case "cron":
+		spec, tz := strings.TrimSpace(r.FormValue("schedule")), strings.TrimSpace(r.FormValue("tz"))
+		if spec != "" || tz != "" {
+			if spec == "" {
+				spec = bean.Schedule
+			}
+			if spec, err = zonedSpec(spec, tz); err != nil {
+				OutputJson(w, 0, "tz错误: "+err.Error(), nil)
+				return
+			}
+			schedule, err = ParseSpec(spec)
+			if err != nil {
+				OutputJson(w, 0, "schedule错误: "+err.Error(), nil)
+				return
+			}
+			if schedule.Next(now).IsZero() {
+				OutputJson(w, 0, "schedule不会触发", nil)
+				return
+			}
+			bean.Schedule, changed = spec, true
+		}
+		if v := r.FormValue("overlap"); v != "" {
+			if bean.Overlap, err = ParseOverlap(v); err != nil {
+				OutputJson(w, 0, "overlap错误: "+err.Error(), nil)
+				return
+			}
+			changed = true
+		}
+		if v := r.FormValue("misfire"); v != "" {
+			if bean.Misfire, err = ParseMisfire(v); err != nil {
+				OutputJson(w, 0, "misfire错误: "+err.Error(), nil)
+				return
+			}
+			changed = true
+		}
+	case "once":
+		if r.FormValue("time") != "" || r.FormValue("delay") != "" {
+			at, err := onceTime(r.FormValue("time"), r.FormValue("delay"), strings.TrimSpace(r.FormValue("tz")), now)
+			if err != nil {
+				OutputJson(w, 0, "时间错误: "+err.Error(), nil)
+				return
+			}
+			if !at.After(now) {
+				OutputJson(w, 0, "时间已过", nil)
+				return
+			}
+			schedule = &OnceSchedule{thetime: at}
+			bean.Schedule, changed = at.Format(onceLayout), true
+		}
+	default:
+		OutputJson(w, 0, "任务不能修改", nil)
+		return
+	}
+	if !changed {
+		OutputJson(w, 0, "没有修改", nil)
+		return
+	}
+
+	bean.Id, bean.Time = id, now
+	if !MainCron.UpdateJob(id, schedule, beanJob(MainCron, bean), WithOverlap(bean.Overlap), WithMisfire(bean.Misfire)) {
+		w.WriteHeader(http.StatusNotFound)
+		OutputJson(w, 0, "任务不存在", nil)
+		return
+	}
+	record := bean
+	record.Method = "update"
+	databk.WriteBin(record)
+	OutputJson(w, 1, "ok", map[string]interface{}{"Id": id})
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func getJobs(query url.Values) (Result, []JobInfo) {
+	r := httptest.NewRequest("GET", "/jobs?"+query.Encode(), nil)
+	w := httptest.NewRecorder()
+	jobsHandler(w, r)
+	var res struct {
+		Result
+		Data []JobInfo
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		panic(err)
+	}
+	return res.Result, res.Data
+}
+
+func TestJobsHandler(t *testing.T) {
+	defer testMain()()
+	MainCron.Start()
+	defer MainCron.Stop()
+
+	post(cronHandler, "/add/cron/", url.Values{"url": {"127.0.0.1/hourly"}, "schedule": {"@hourly"}})
+	post(onceHandler, "/add/once/", url.Values{"url": {"127.0.0.1/once"}, "delay": {"2h"}})
+	MainCron.AddFunc("@every 100h", func(id int64) {})
+
+	Convey("Every entry is listed with its id, spec and times.", t, func() {
+		res, jobs := getJobs(nil)
+		So(res.Ret, ShouldEqual, 1)
+		So(len(jobs), ShouldEqual, 3)
+		So(jobs[0].Id, ShouldNotEqual, 0)
+		So(jobs[0].Method, ShouldEqual, "cron")
+		So(jobs[0].Schedule, ShouldEqual, "@hourly")
+		So(jobs[0].Url, ShouldEqual, "http://127.0.0.1/hourly")
+		So(jobs[0].Next.After(time.Now()), ShouldBeTrue)
+		So(jobs[0].Prev.IsZero(), ShouldBeTrue)
+	})
+
+	Convey("Jobs can be filtered by method, url and next run.", t, func() {
+		_, jobs := getJobs(url.Values{"method": {"once"}})
+		So(len(jobs), ShouldEqual, 1)
+		So(jobs[0].Url, ShouldEqual, "http://127.0.0.1/once")
+
+		_, jobs = getJobs(url.Values{"url": {"hourly"}})
+		So(len(jobs), ShouldEqual, 1)
+
+		_, jobs = getJobs(url.Values{"before": {time.Now().Add(61 * time.Minute).Format(time.RFC3339)}})
+		So(len(jobs), ShouldEqual, 1)
+		So(jobs[0].Method, ShouldEqual, "cron")
+
+		_, jobs = getJobs(url.Values{"after": {time.Now().Add(90 * time.Minute).Format(time.RFC3339)}})
+		So(len(jobs), ShouldEqual, 2)
+	})
+
+	Convey("Bad windows and methods are rejected.", t, func() {
+		res, _ := getJobs(url.Values{"after": {"tomorrow"}})
+		So(res.Ret, ShouldEqual, 0)
+
+		w := httptest.NewRecorder()
+		jobsHandler(w, httptest.NewRequest("POST", "/jobs", nil))
+		So(w.Code, ShouldEqual, http.StatusMethodNotAllowed)
+	})
+}
+
+func jobRequest(method, path string) (int, Result) {
+	w := httptest.NewRecorder()
+	jobHandler(w, httptest.NewRequest(method, path, nil))
+	var res Result
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		panic(err)
+	}
+	return w.Code, res
+}
+
+func TestJobHandler(t *testing.T) {
+	defer testMain()()
+	MainCron.Start()
+	defer MainCron.Stop()
+
+	calls := make(chan bool, 10)
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls <- true
+	}))
+	defer target.Close()
+
+	post(cronHandler, "/add/cron/", url.Values{"url": {target.URL}, "schedule": {"* * * * * ?"}})
+	post(cronHandler, "/add/cron/", url.Values{"url": {target.URL + "/daily"}, "schedule": {"@daily"}})
+	_, jobs := getJobs(url.Values{"url": {"daily"}})
+	daily := "/jobs/" + strconv.FormatInt(jobs[0].Id, 10)
+	_, jobs = getJobs(url.Values{"url": {target.URL}, "before": {time.Now().Add(time.Minute).Format(time.RFC3339)}})
+	path := "/jobs/" + strconv.FormatInt(jobs[0].Id, 10)
+
+	Convey("A paused job stays listed but does not run.", t, func() {
+		code, res := jobRequest("POST", path+"/pause")
+		So(code, ShouldEqual, http.StatusOK)
+		So(res.Ret, ShouldEqual, 1)
+		for len(calls) > 0 {
+			<-calls
+		}
+
+		_, jobs := getJobs(url.Values{"url": {target.URL}})
+		So(len(jobs), ShouldEqual, 2)
+		So(jobs[1].Paused, ShouldBeTrue)
+		So(jobs[1].Next.IsZero(), ShouldBeTrue)
+
+		tag := true
+		select {
+		case <-time.After(ONE_SECOND):
+		case <-calls:
+			tag = false
+		}
+		So(tag, ShouldBeTrue)
+	})
+
+	Convey("A resumed job runs again.", t, func() {
+		code, _ := jobRequest("POST", path+"/resume")
+		So(code, ShouldEqual, http.StatusOK)
+		tag := false
+		select {
+		case <-time.After(2 * ONE_SECOND):
+		case tag = <-calls:
+		}
+		So(tag, ShouldBeTrue)
+	})
+
+	Convey("A deleted job is gone.", t, func() {
+		code, _ := jobRequest("DELETE", path)
+		So(code, ShouldEqual, http.StatusOK)
+		_, jobs := getJobs(nil)
+		So(len(jobs), ShouldEqual, 1)
+
+		code, _ = jobRequest("DELETE", path)
+		So(code, ShouldEqual, http.StatusNotFound)
+		code, _ = jobRequest("GET", path+"/pause")
+		So(code, ShouldEqual, http.StatusMethodNotAllowed)
+		code, _ = jobRequest("POST", "/jobs/abc/pause")
+		So(code, ShouldEqual, http.StatusNotFound)
+	})
+
+	Convey("State changes survive a restart.", t, func() {
+		jobRequest("POST", daily+"/pause")
+		cron := New()
+		n, err := Replay(databk.logfile.Name(), cron, RecoverSkip)
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, 1)
+		So(cron.entries[0].Paused, ShouldBeTrue)
+	})
+}
+
+func putJob(path string, form url.Values) (int, Result) {
+	r := httptest.NewRequest("PUT", path, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	jobHandler(w, r)
+	var res Result
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		panic(err)
+	}
+	return w.Code, res
+}
+
+func TestUpdateHandler(t *testing.T) {
+	defer testMain()()
+	MainCron.Start()
+	defer MainCron.Stop()
+
+	post(cronHandler, "/add/cron/", url.Values{"url": {"127.0.0.1/cron"}, "schedule": {"@daily"}})
+	post(onceHandler, "/add/once/", url.Values{"url": {"127.0.0.1/once"}, "delay": {"3h"}})
+	_, jobs := getJobs(url.Values{"method": {"cron"}})
+	cron := "/jobs/" + strconv.FormatInt(jobs[0].Id, 10)
+	_, jobs = getJobs(url.Values{"method": {"once"}})
+	once := "/jobs/" + strconv.FormatInt(jobs[0].Id, 10)
+
+	Convey("A cron job keeps its id when its schedule and url change.", t, func() {
+		code, res := putJob(cron, url.Values{"schedule": {"@hourly"}, "url": {"127.0.0.1/hourly"}})
+		So(code, ShouldEqual, http.StatusOK)
+		So(res.Ret, ShouldEqual, 1)
+
+		_, jobs := getJobs(url.Values{"method": {"cron"}})
+		So(len(jobs), ShouldEqual, 1)
+		So("/jobs/"+strconv.FormatInt(jobs[0].Id, 10), ShouldEqual, cron)
+		So(jobs[0].Schedule, ShouldEqual, "@hourly")
+		So(jobs[0].Url, ShouldEqual, "http://127.0.0.1/hourly")
+		So(jobs[0].Next.Before(time.Now().Add(time.Hour+time.Second)), ShouldBeTrue)
+	})
+
+	Convey("A once job can be moved.", t, func() {
+		code, _ := putJob(once, url.Values{"delay": {"10m"}})
+		So(code, ShouldEqual, http.StatusOK)
+		_, jobs := getJobs(url.Values{"method": {"once"}})
+		So(jobs[0].Next.Before(time.Now().Add(11*time.Minute)), ShouldBeTrue)
+	})
+
+	Convey("Invalid updates are rejected.", t, func() {
+		_, res := putJob(cron, url.Values{"schedule": {"0 75 * * *"}})
+		So(res.Ret, ShouldEqual, 0)
+		_, res = putJob(cron, url.Values{})
+		So(res.Ret, ShouldEqual, 0)
+		_, res = putJob(once, url.Values{"delay": {"-1m"}})
+		So(res.Ret, ShouldEqual, 0)
+		code, _ := putJob("/jobs/1", url.Values{"delay": {"1m"}})
+		So(code, ShouldEqual, http.StatusNotFound)
+	})
+
+	Convey("Updates survive a restart.", t, func() {
+		cron := New()
+		n, err := Replay(databk.logfile.Name(), cron, RecoverSkip)
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, 2)
+		So(cron.entries[0].Job.(*CallJob).Bean.Method, ShouldEqual, "cron")
+		So(cron.entries[0].Job.(*CallJob).Bean.Schedule, ShouldEqual, "@hourly")
+	})
+}
@@ -3,14 +3,19 @@ package main
 import (
 	//	"bytes"
 	//	"encoding/gob"
+	"bufio"
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
 	"os"
+	"sync"
 	"time"
 )
 
 type Logbk struct {
+	mu      sync.Mutex
 	logfile *os.File
 	running bool
 }
@@ -21,6 +26,23 @@ type Bean struct {
 	Method   string
 	Url      string
 	Schedule string
+	Callback *Callback `json:",omitempty"`
+	Retry    *Retry    `json:",omitempty"`
+	Nsq      *Publish  `json:",omitempty"`
+	Overlap  Overlap   `json:",omitempty"`
+	Misfire  Misfire   `json:",omitempty"`
+
+	// How an add request may give the fire time of a once job as a Delay
+	// from now, such as "90s", and the time zone its time, or the spec of a
+	// cron job, is read in. They are folded into Schedule as the job is
+	// added.
+	Delay string `json:",omitempty"`
+	Tz    string `json:",omitempty"`
+
+	// The outcome of one attempt of a job, for attempt records.
+	Attempt int    `json:",omitempty"`
+	Status  int    `json:",omitempty"`
+	Error   string `json:",omitempty"`
 }
 
 func Newbk(filename string) (_ *Logbk, err error) {
@@ -35,26 +57,67 @@ func Newbk(filename string) (_ *Logbk, err error) {
 }
 
 func (l *Logbk) WriteBin(bin Bean) error {
+	return l.WriteJson(bin)
+}
 
-	if l.running {
-		b, err := json.Marshal(bin)
-		if err != nil {
-			fmt.Println("error:", err)
-			return err
-		}
-
-		l.logfile.Write(b)
-		l.logfile.WriteString("\r\n")
-		return nil
+// WriteJson appends v to the log as a line of JSON.
+func (l *Logbk) WriteJson(v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		fmt.Println("error:", err)
+		return err
 	}
-	return errors.New("There is no log file link")
+
+	return l.Write(string(b))
 }
 
 func (l *Logbk) Write(line string) error {
+	// Lines are written in one go, so that writers on other goroutines
+	// cannot split them.
+	l.mu.Lock()
+	defer l.mu.Unlock()
 	if l.running {
-		l.logfile.WriteString(line)
-		l.logfile.WriteString("\r\n")
-		return nil
+		_, err := l.logfile.WriteString(line + "\r\n")
+		return err
 	}
 	return errors.New("There is no log file link")
 }
+
+// Close closes the log file. Writes after it fail, and a write going on when
+// it is called is finished first, so no line is cut short.
+func (l *Logbk) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.running {
+		return nil
+	}
+	l.running = false
+	return l.logfile.Close()
+}
+
+// ReadJson calls fn with every line of JSON written to filename, in order.
+// Lines that do not decode are logged and skipped, since a crash can leave
+// the last one cut short. A missing file reads as empty.
+func ReadJson(filename string, fn func(data []byte) error) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		if err := fn(data); err != nil {
+			log.Printf("%s:%d: %s", filename, line, err)
+		}
+	}
+	return scanner.Err()
+}
@@ -18,5 +18,9 @@ func TestLogbk(t *testing.T) {
 		So(err, ShouldBeNil)
 
 	})
-	defer databk.logfile.Close()
+	Convey("Nothing is written once the file is closed.", t, func() {
+		So(databk.Close(), ShouldBeNil)
+		So(databk.Write("this is a late test!"), ShouldNotBeNil)
+		So(databk.Close(), ShouldBeNil)
+	})
 }
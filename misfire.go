@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Misfire says what happens to the activations of an entry that were missed,
+// because the scheduler woke up late or was not running: the entry runs once
+// for all of them, not at all, or once for each of them, up to a number of
+// runs.
+type Misfire int
+
+const (
+	MisfireFireOnce Misfire = 0
+	MisfireSkip     Misfire = -1
+)
+
+// MisfireFireAll runs an entry once for every activation missed, but no more
+// than max times.
+func MisfireFireAll(max int) Misfire {
+	if max < 1 {
+		return MisfireSkip
+	}
+	return Misfire(max)
+}
+
+// DefaultMisfireThreshold is how late an activation may be run before it
+// counts as missed.
+const DefaultMisfireThreshold = time.Second
+
+// ErrMisfired is what a SkipJob is told about activations it missed and that
+// are not made up for.
+var ErrMisfired = errors.New("skipped: misfired")
+
+// ParseMisfire reads "once", "skip" or "all:n", the forms String gives.
+func ParseMisfire(s string) (Misfire, error) {
+	switch s = strings.TrimSpace(s); {
+	case s == "" || s == "once":
+		return MisfireFireOnce, nil
+	case s == "skip":
+		return MisfireSkip, nil
+	case strings.HasPrefix(s, "all:"):
+		n, err := strconv.Atoi(s[len("all:"):])
+		if err != nil {
+			return 0, err
+		}
+		if n < 1 {
+			return 0, errors.New("all should be at least 1")
+		}
+		return MisfireFireAll(n), nil
+	}
+	return 0, fmt.Errorf("unknown misfire %q", s)
+}
+
+func (m Misfire) String() string {
+	switch {
+	case m == MisfireFireOnce:
+		return "once"
+	case m < 0:
+		return "skip"
+	}
+	return "all:" + strconv.Itoa(int(m))
+}
+
+func (m Misfire) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+func (m *Misfire) UnmarshalText(text []byte) error {
+	v, err := ParseMisfire(string(text))
+	if err != nil {
+		return err
+	}
+	*m = v
+	return nil
+}
+
+// runs returns how many of the missed activations are run.
+func (m Misfire) runs(missed int) int {
+	switch {
+	case m == MisfireFireOnce:
+		return 1
+	case m < 0:
+		return 0
+	case missed > int(m):
+		return int(m)
+	}
+	return missed
+}
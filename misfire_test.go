@@ -0,0 +1,147 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// lateSchedule first activates late ago, as if the scheduler overslept, and
+// then every hour.
+type lateSchedule struct {
+	late time.Duration
+	woke bool
+}
+
+func (s *lateSchedule) Next(t time.Time) time.Time {
+	if !s.woke {
+		s.woke = true
+		return t.Add(-s.late)
+	}
+	return t.Add(time.Hour)
+}
+
+func TestParseMisfire(t *testing.T) {
+	Convey("Misfires read back what they print.", t, func() {
+		for _, m := range []Misfire{MisfireFireOnce, MisfireSkip, MisfireFireAll(1), MisfireFireAll(5)} {
+			v, err := ParseMisfire(m.String())
+			So(err, ShouldBeNil)
+			So(v, ShouldEqual, m)
+		}
+		So(MisfireFireAll(0), ShouldEqual, MisfireSkip)
+		for _, s := range []string{"twice", "all", "all:0", "all:x"} {
+			_, err := ParseMisfire(s)
+			So(err, ShouldNotBeNil)
+		}
+	})
+
+	Convey("Each policy runs its share of the missed activations.", t, func() {
+		So(MisfireFireOnce.runs(3), ShouldEqual, 1)
+		So(MisfireSkip.runs(3), ShouldEqual, 0)
+		So(MisfireFireAll(2).runs(3), ShouldEqual, 2)
+		So(MisfireFireAll(5).runs(3), ShouldEqual, 3)
+	})
+}
+
+func TestMisfire(t *testing.T) {
+	// An hourly entry last on time three hours ago has missed three
+	// activations by now.
+	missed := func(m Misfire) *slowJob {
+		cron := New()
+		job := newSlowJob()
+		close(job.release)
+		cron.Schedule(tickSchedule{time.Hour}, job, WithMisfire(m), WithMissedSince(time.Now().Add(-3*time.Hour)))
+		cron.Start()
+		time.Sleep(50 * time.Millisecond)
+		entries := cron.Entries()
+		So(entries[0].Next.After(time.Now().Add(50*time.Minute)), ShouldBeTrue)
+		cron.Stop()
+		return job
+	}
+
+	Convey("Missed activations are run once by default.", t, func() {
+		job := missed(MisfireFireOnce)
+		So(len(job.started), ShouldEqual, 1)
+		So(<-job.skips, ShouldEqual, ErrMisfired)
+	})
+
+	Convey("They are run one by one, up to a cap, with FireAll.", t, func() {
+		So(len(missed(MisfireFireAll(5)).started), ShouldEqual, 3)
+		job := missed(MisfireFireAll(2))
+		So(len(job.started), ShouldEqual, 2)
+		So(len(job.skips), ShouldEqual, 1)
+	})
+
+	Convey("They are not run with Skip.", t, func() {
+		job := missed(MisfireSkip)
+		So(len(job.started), ShouldEqual, 0)
+		So(<-job.skips, ShouldEqual, ErrMisfired)
+	})
+
+	Convey("The policy applies when the scheduler wakes up late.", t, func() {
+		cron := New(WithMisfireThreshold(10 * time.Millisecond))
+		cron.Start()
+		defer cron.Stop()
+		job := newSlowJob()
+		close(job.release)
+		cron.Schedule(&lateSchedule{late: 100 * time.Millisecond}, job, WithMisfire(MisfireSkip))
+		time.Sleep(50 * time.Millisecond)
+		So(len(job.started), ShouldEqual, 0)
+		So(len(job.skips), ShouldEqual, 1)
+		So(cron.Entries()[0].Next.After(time.Now()), ShouldBeTrue)
+	})
+
+	Convey("Entries a little late are run as usual.", t, func() {
+		cron := New()
+		cron.Start()
+		defer cron.Stop()
+		job := newSlowJob()
+		close(job.release)
+		cron.Schedule(&lateSchedule{late: 100 * time.Millisecond}, job, WithMisfire(MisfireSkip))
+		time.Sleep(50 * time.Millisecond)
+		So(len(job.started), ShouldEqual, 1)
+		So(len(job.skips), ShouldEqual, 0)
+	})
+
+	Convey("Deleting another entry does not make the next activation late.", t, func() {
+		cron := New(WithMisfireThreshold(20 * time.Millisecond))
+		cron.Start()
+		defer cron.Stop()
+		job := newSlowJob()
+		close(job.release)
+		cron.Schedule(tickSchedule{200 * time.Millisecond}, job, WithMisfire(MisfireSkip))
+		other := cron.Schedule(tickSchedule{time.Hour}, FuncJob(func(int64) {}))
+		<-job.started
+		time.Sleep(100 * time.Millisecond)
+		So(cron.DelJob(other), ShouldBeTrue)
+		select {
+		case <-job.started:
+		case <-time.After(150 * time.Millisecond):
+			t.Error("the next activation did not run on time")
+		}
+		So(len(job.skips), ShouldEqual, 0)
+	})
+}
+
+func TestReplayMisfire(t *testing.T) {
+	created := time.Now().Add(-3 * time.Hour)
+	ran := time.Now().Add(-2 * time.Hour)
+	journal := writeJournal(
+		Bean{Id: 1, Time: created, Method: "cron", Url: "http://127.0.0.1/cb", Schedule: "@hourly", Misfire: MisfireFireAll(4)},
+		Bean{Id: 1, Time: ran, Method: "attempt", Attempt: 1},
+		Bean{Id: 2, Time: created, Method: "cron", Url: "http://127.0.0.1/cb", Schedule: "@hourly"},
+	)
+	defer os.Remove(journal)
+
+	Convey("Restored cron jobs have missed what came due since they last ran.", t, func() {
+		cron := New()
+		n, err := Replay(journal, cron, RecoverSkip)
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, 2)
+		So(cron.entries[0].Misfire, ShouldEqual, MisfireFireAll(4))
+		So(cron.entries[0].since.Equal(ran), ShouldBeTrue)
+		So(cron.entries[1].since.Equal(created), ShouldBeTrue)
+	})
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Publish describes the messages a job sends to nsq instead of calling a url.
+type Publish struct {
+	Topic    string
+	Messages []string
+}
+
+// Nsq publishes messages through the HTTP api of an nsqd.
+type Nsq struct {
+	// The host:port nsqd serves HTTP on.
+	Address string
+
+	// The topic used when a job does not name one.
+	Topic string
+
+	Client *http.Client
+}
+
+// NewNsq returns a publisher for the nsqd serving HTTP on address.
+func NewNsq(address, topic string) *Nsq {
+	return &Nsq{
+		Address: address,
+		Topic:   topic,
+		Client:  &http.Client{Timeout: DefaultTimeout},
+	}
+}
+
+var topicName = regexp.MustCompile(`^[.a-zA-Z0-9_-]{1,64}(#ephemeral)?$`)
+
+// validTopic reports whether nsqd accepts name as a topic.
+func validTopic(name string) bool {
+	return topicName.MatchString(name) && len(name) <= 64
+}
+
+// check reports what is wrong with p, if anything.
+func (p *Publish) check() error {
+	if !validTopic(p.Topic) {
+		return fmt.Errorf("invalid topic %q", p.Topic)
+	}
+	if len(p.Messages) == 0 {
+		return errors.New("missing message")
+	}
+	for _, m := range p.Messages {
+		if m == "" {
+			return errors.New("empty message")
+		}
+	}
+	return nil
+}
+
+// Publish sends messages to topic, through /pub for a single message and
+// /mpub for more, giving up when ctx is done. It returns the status and the
+// start of nsqd's response.
+func (n *Nsq) Publish(ctx context.Context, topic string, messages []string) (int, string, error) {
+	if topic == "" {
+		topic = n.Topic
+	}
+	if !validTopic(topic) {
+		return 0, "", fmt.Errorf("invalid topic %q", topic)
+	}
+
+	var (
+		path = "/pub"
+		body bytes.Buffer
+		args = url.Values{"topic": {topic}}
+	)
+	switch len(messages) {
+	case 0:
+		return 0, "", errors.New("no message to publish")
+	case 1:
+		body.WriteString(messages[0])
+	default:
+		// The binary format lets messages hold newlines:
+		// [count][size][message][size][message]...
+		path = "/mpub"
+		args.Set("binary", "true")
+		binary.Write(&body, binary.BigEndian, uint32(len(messages)))
+		for _, m := range messages {
+			binary.Write(&body, binary.BigEndian, uint32(len(m)))
+			body.WriteString(m)
+		}
+	}
+
+	address := n.Address
+	if !strings.HasPrefix(address, "http") {
+		address = "http://" + address
+	}
+	client := n.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", address+path+"?"+args.Encode(), &body)
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	snippet, err := ioutil.ReadAll(io.LimitReader(resp.Body, SnippetSize))
+	if err == nil {
+		_, err = io.Copy(ioutil.Discard, resp.Body)
+	}
+	if resp.StatusCode != http.StatusOK {
+		err = &StatusError{resp.StatusCode}
+	}
+	return resp.StatusCode, string(snippet), err
+}
+
+// publish sends the messages of p through nsqd, when it is configured.
+func publish(ctx context.Context, p *Publish) (int, string, error) {
+	if nsqd == nil {
+		return 0, "", errors.New("nsqd is not configured")
+	}
+	return nsqd.Publish(ctx, p.Topic, p.Messages)
+}
+
+// parsePublish reads the nsq target of an add request:
+//
+//	topic   - the topic, the configured one by default
+//	message - a message to publish, may be repeated
+func parsePublish(r *http.Request) *Publish {
+	return &Publish{Topic: r.FormValue("topic"), Messages: r.Form["message"]}
+}
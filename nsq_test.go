@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fakeNsqd stands in for the HTTP api of nsqd, passing on what it is sent.
+func fakeNsqd(published chan []string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		topic := r.URL.Query().Get("topic")
+		if !validTopic(topic) {
+			http.Error(w, "INVALID_TOPIC", http.StatusBadRequest)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		msgs := []string{topic}
+		switch r.URL.Path {
+		case "/pub":
+			msgs = append(msgs, string(body))
+		case "/mpub":
+			if r.URL.Query().Get("binary") != "true" {
+				http.Error(w, "NOT_BINARY", http.StatusBadRequest)
+				return
+			}
+			buf := bytes.NewReader(body)
+			var n, size uint32
+			binary.Read(buf, binary.BigEndian, &n)
+			for i := uint32(0); i < n; i++ {
+				binary.Read(buf, binary.BigEndian, &size)
+				m := make([]byte, size)
+				buf.Read(m)
+				msgs = append(msgs, string(m))
+			}
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		published <- msgs
+		w.Write([]byte("OK"))
+	}))
+}
+
+func TestNsqPublish(t *testing.T) {
+	published := make(chan []string, 1)
+	server := fakeNsqd(published)
+	defer server.Close()
+	n := NewNsq(strings.TrimPrefix(server.URL, "http://"), "job")
+	ctx := context.Background()
+
+	Convey("A single message goes through /pub.", t, func() {
+		status, body, err := n.Publish(ctx, "", []string{"hello"})
+		So(err, ShouldBeNil)
+		So(status, ShouldEqual, http.StatusOK)
+		So(body, ShouldEqual, "OK")
+		So(<-published, ShouldResemble, []string{"job", "hello"})
+	})
+
+	Convey("Several messages go through /mpub, newlines and all.", t, func() {
+		_, _, err := n.Publish(ctx, "orders", []string{"a\nb", "c"})
+		So(err, ShouldBeNil)
+		So(<-published, ShouldResemble, []string{"orders", "a\nb", "c"})
+	})
+
+	Convey("Bad topics and nsqd errors are reported.", t, func() {
+		_, _, err := n.Publish(ctx, "bad topic", []string{"x"})
+		So(err, ShouldNotBeNil)
+		_, _, err = n.Publish(ctx, "job", nil)
+		So(err, ShouldNotBeNil)
+		server.Close()
+		_, _, err = n.Publish(ctx, "job", []string{"x"})
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestNsqTarget(t *testing.T) {
+	defer testMain()()
+	MainCron.Start()
+	defer MainCron.Stop()
+
+	published := make(chan []string, 1)
+	server := fakeNsqd(published)
+	defer server.Close()
+
+	Convey("Without nsqd, nsq targets are refused.", t, func() {
+		nsqd = nil
+		res := post(nowHandler, "/add/now/", url.Values{"target": {"nsq"}, "message": {"m"}})
+		So(res.Ret, ShouldEqual, 0)
+	})
+
+	nsqd = NewNsq(server.URL, "job")
+	defer func() { nsqd = nil }()
+
+	Convey("A job can publish to nsq when it fires.", t, func() {
+		res := post(nowHandler, "/add/now/", url.Values{"target": {"nsq"}, "topic": {"delayed"}, "message": {"m1", "m2"}})
+		So(res.Ret, ShouldEqual, 1)
+		tag := false
+		select {
+		case <-time.After(ONE_SECOND):
+		case msgs := <-published:
+			So(msgs, ShouldResemble, []string{"delayed", "m1", "m2"})
+			tag = true
+		}
+		So(tag, ShouldBeTrue)
+	})
+
+	Convey("Nsq jobs are listed with their topic.", t, func() {
+		post(onceHandler, "/add/once/", url.Values{"target": {"nsq"}, "message": {"m"}, "delay": {"1h"}})
+		_, jobs := getJobs(url.Values{"method": {"once"}})
+		So(len(jobs), ShouldEqual, 1)
+		So(jobs[0].Topic, ShouldEqual, "job")
+		So(jobs[0].Url, ShouldBeEmpty)
+	})
+
+	Convey("Bad nsq targets are rejected.", t, func() {
+		for _, form := range []url.Values{
+			{"target": {"nsq"}},
+			{"target": {"nsq"}, "message": {""}},
+			{"target": {"nsq"}, "message": {"m"}, "topic": {"no/slash"}},
+		} {
+			So(post(nowHandler, "/add/now/", form).Ret, ShouldEqual, 0)
+		}
+	})
+}
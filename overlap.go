@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Overlap says what happens when an entry is due while its job is still
+// running: it runs anyway, it is skipped, or it is queued to run once the
+// job returns, up to a number of runs.
+type Overlap int
+
+const (
+	OverlapAllow Overlap = 0
+	OverlapSkip  Overlap = -1
+)
+
+// OverlapQueue queues up to n runs of a job that is still running. Runs due
+// while the queue is full are skipped.
+func OverlapQueue(n int) Overlap {
+	if n < 1 {
+		return OverlapSkip
+	}
+	return Overlap(n)
+}
+
+// The errors a SkipJob is told about.
+var (
+	ErrStillRunning = errors.New("skipped: still running")
+	ErrQueueFull    = errors.New("skipped: queue full")
+)
+
+// ParseOverlap reads "allow", "skip" or "queue:n", the forms String gives.
+// A plain "queue" queues one run.
+func ParseOverlap(s string) (Overlap, error) {
+	switch s = strings.TrimSpace(s); {
+	case s == "" || s == "allow":
+		return OverlapAllow, nil
+	case s == "skip":
+		return OverlapSkip, nil
+	case s == "queue":
+		return OverlapQueue(1), nil
+	case strings.HasPrefix(s, "queue:"):
+		n, err := strconv.Atoi(s[len("queue:"):])
+		if err != nil {
+			return 0, err
+		}
+		if n < 1 {
+			return 0, errors.New("queue should be at least 1")
+		}
+		return OverlapQueue(n), nil
+	}
+	return 0, fmt.Errorf("unknown overlap %q", s)
+}
+
+func (o Overlap) String() string {
+	switch {
+	case o == OverlapAllow:
+		return "allow"
+	case o < 0:
+		return "skip"
+	}
+	return "queue:" + strconv.Itoa(int(o))
+}
+
+func (o Overlap) MarshalText() ([]byte, error) {
+	return []byte(o.String()), nil
+}
+
+func (o *Overlap) UnmarshalText(text []byte) error {
+	v, err := ParseOverlap(string(text))
+	if err != nil {
+		return err
+	}
+	*o = v
+	return nil
+}
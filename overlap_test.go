@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// tickSchedule activates every d, for schedules quicker than cron allows.
+type tickSchedule struct {
+	d time.Duration
+}
+
+func (s tickSchedule) Next(t time.Time) time.Time { return t.Add(s.d) }
+
+// slowJob runs until it is released, counting its runs and passing on the
+// runs that are skipped.
+type slowJob struct {
+	started chan bool
+	release chan bool
+	skips   chan error
+}
+
+func newSlowJob() *slowJob {
+	return &slowJob{make(chan bool, 100), make(chan bool), make(chan error, 100)}
+}
+
+func (j *slowJob) Run(id int64) {
+	j.started <- true
+	<-j.release
+}
+
+func (j *slowJob) Skip(id int64, err error) { j.skips <- err }
+
+func TestParseOverlap(t *testing.T) {
+	Convey("Overlaps read back what they print.", t, func() {
+		for _, o := range []Overlap{OverlapAllow, OverlapSkip, OverlapQueue(1), OverlapQueue(3)} {
+			v, err := ParseOverlap(o.String())
+			So(err, ShouldBeNil)
+			So(v, ShouldEqual, o)
+		}
+		v, err := ParseOverlap("queue")
+		So(err, ShouldBeNil)
+		So(v, ShouldEqual, OverlapQueue(1))
+		So(OverlapQueue(0), ShouldEqual, OverlapSkip)
+
+		b, _ := json.Marshal(Bean{Overlap: OverlapQueue(2)})
+		var bean Bean
+		So(json.Unmarshal(b, &bean), ShouldBeNil)
+		So(bean.Overlap, ShouldEqual, OverlapQueue(2))
+	})
+
+	Convey("Bad overlaps are rejected.", t, func() {
+		for _, s := range []string{"sometimes", "queue:", "queue:0", "queue:x"} {
+			_, err := ParseOverlap(s)
+			So(err, ShouldNotBeNil)
+		}
+	})
+}
+
+func TestOverlap(t *testing.T) {
+	Convey("Runs due while the job is running go ahead with Allow.", t, func() {
+		cron := New()
+		job := newSlowJob()
+		cron.Schedule(tickSchedule{20 * time.Millisecond}, job)
+		cron.Start()
+		time.Sleep(110 * time.Millisecond)
+		cron.Stop()
+		close(job.release)
+		So(len(job.started), ShouldBeGreaterThan, 1)
+		So(len(job.skips), ShouldEqual, 0)
+	})
+
+	Convey("They are skipped with Skip.", t, func() {
+		cron := New()
+		job := newSlowJob()
+		cron.Schedule(tickSchedule{20 * time.Millisecond}, job, WithOverlap(OverlapSkip))
+		cron.Start()
+		time.Sleep(110 * time.Millisecond)
+		cron.Stop()
+		close(job.release)
+		So(len(job.started), ShouldEqual, 1)
+		So(len(job.skips), ShouldBeGreaterThan, 1)
+		So(<-job.skips, ShouldEqual, ErrStillRunning)
+	})
+
+	Convey("They wait their turn with Queue, as long as there is room.", t, func() {
+		cron := New()
+		job := newSlowJob()
+		id := cron.Schedule(tickSchedule{20 * time.Millisecond}, job, WithOverlap(OverlapQueue(2)))
+		cron.Start()
+		time.Sleep(110 * time.Millisecond)
+		cron.DelJob(id)
+		So(len(job.started), ShouldEqual, 1)
+		So(len(job.skips), ShouldBeGreaterThan, 1)
+		So(<-job.skips, ShouldEqual, ErrQueueFull)
+
+		// Deleting the job drops the runs still queued.
+		job.release <- true
+		time.Sleep(20 * time.Millisecond)
+		So(len(job.started), ShouldEqual, 1)
+		cron.Stop()
+	})
+
+	Convey("Queued runs follow each other.", t, func() {
+		cron := New()
+		job := newSlowJob()
+		cron.Schedule(tickSchedule{20 * time.Millisecond}, job, WithOverlap(OverlapQueue(2)))
+		cron.Start()
+		time.Sleep(70 * time.Millisecond)
+		cron.PauseJob(cron.Entries()[0].Id)
+		for i := 0; i < 3; i++ {
+			job.release <- true
+		}
+		time.Sleep(20 * time.Millisecond)
+		So(len(job.started), ShouldEqual, 3)
+		cron.Stop()
+	})
+}
+
+func TestOverlapHandler(t *testing.T) {
+	defer testMain()()
+
+	Convey("The overlap of cron jobs is set and changed over HTTP.", t, func() {
+		res := post(cronHandler, "/add/cron/", url.Values{"url": {"127.0.0.1/cb"}, "schedule": {"@every 1h"}, "overlap": {"skip"}})
+		So(res.Ret, ShouldEqual, 1)
+		_, jobs := getJobs(nil)
+		So(jobs[0].Overlap, ShouldEqual, OverlapSkip)
+
+		path := "/jobs/" + strconv.FormatInt(jobs[0].Id, 10)
+		code, _ := putJob(path, url.Values{"overlap": {"queue:3"}})
+		So(code, ShouldEqual, http.StatusOK)
+		_, jobs = getJobs(nil)
+		So(jobs[0].Overlap, ShouldEqual, OverlapQueue(3))
+
+		cron := New()
+		Replay(databk.logfile.Name(), cron, RecoverSkip)
+		So(cron.entries[0].Overlap, ShouldEqual, OverlapQueue(3))
+
+		res = post(cronHandler, "/add/cron/", url.Values{"url": {"127.0.0.1/cb"}, "schedule": {"@every 1h"}, "overlap": {"never"}})
+		So(res.Ret, ShouldEqual, 0)
+	})
+
+	Convey("Skipped runs are recorded in the history.", t, func() {
+		release := make(chan bool)
+		target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-release
+		}))
+		defer target.Close()
+
+		job := beanJob(MainCron, Bean{Method: "cron", Url: target.URL})
+		id := MainCron.Schedule(tickSchedule{30 * time.Millisecond}, job, WithOverlap(OverlapSkip))
+		MainCron.Start()
+		time.Sleep(100 * time.Millisecond)
+		MainCron.DelJob(id)
+		close(release)
+		MainCron.Stop()
+
+		runs := history.Runs(id)
+		So(len(runs), ShouldBeGreaterThan, 1)
+		So(runs[0].Skipped, ShouldBeTrue)
+		So(runs[0].Error, ShouldEqual, ErrStillRunning.Error())
+	})
+}
@@ -1,29 +1,94 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	"math"
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
 )
 
+// ParseError describes why a spec was rejected.
+type ParseError struct {
+	// The spec being parsed.
+	Spec string
+
+	// The field the error was found in, e.g. "minute", or "" when it is not
+	// about a single field.
+	Field string
+
+	// The offending token.
+	Token string
+
+	// The bounds allowed in Field.
+	Min, Max uint
+
+	// What is wrong with Token.
+	Reason string
+}
+
+func (e *ParseError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("%s: %s", e.Reason, e.Spec)
+	}
+	return fmt.Sprintf("%s field %q: %s (allowed %d-%d)", e.Field, e.Token, e.Reason, e.Min, e.Max)
+}
+
 // Parse returns a new crontab schedule representing the given spec.
 // It panics with a descriptive error if the spec is not valid.
 //
 // It accepts
-//   - Full crontab specs, e.g. "* * * * * ?"
+//   - Full crontab specs, e.g. "* * * * * ?", with the Quartz day forms "L",
+//     "LW" and "15W" for the day of month, and "5L" and "2#2" for the day of
+//     week, see SpecSchedule, and an optional seventh field for the year,
+//     e.g. "0 0 0 1 1 ? 2027-2030"
 //   - Descriptors, e.g. "@midnight", "@every 1h30m"
+//   - Either, read in a time zone, e.g. "CRON_TZ=Asia/Shanghai 0 0 9 * * *"
+//     or "TZ=UTC @daily"
 func Parse(spec string) Schedule {
+	schedule, err := ParseSpec(spec)
+	if err != nil {
+		log.Panic(err)
+	}
+	return schedule
+}
+
+// ParseSpec is like Parse but reports an invalid spec with a *ParseError
+// instead of panicking.
+func ParseSpec(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, &ParseError{Spec: spec, Reason: "Empty spec"}
+	}
+
+	// A leading TZ= or CRON_TZ= gives the time zone the spec is read in.
+	var loc *time.Location
+	if strings.HasPrefix(spec, "TZ=") || strings.HasPrefix(spec, "CRON_TZ=") {
+		var err error
+		if loc, spec, err = parseZone(spec); err != nil {
+			return nil, err
+		}
+	}
+
 	if spec[0] == '@' {
-		return parseDescriptor(spec)
+		schedule, err := parseDescriptor(spec)
+		if s, ok := schedule.(*SpecSchedule); ok {
+			s.Location = loc
+		}
+		return schedule, err
 	}
 
-	// Split on whitespace.  We require 5 or 6 fields.
+	// Split on whitespace.  We require 5 to 7 fields.
 	// (second) (minute) (hour) (day of month) (month) (day of week, optional)
+	// (year, optional)
 	fields := strings.Fields(spec)
-	if len(fields) != 5 && len(fields) != 6 {
-		log.Panicf("Expected 5 or 6 fields, found %d: %s", len(fields), spec)
+	if len(fields) < 5 || len(fields) > 7 {
+		return nil, &ParseError{
+			Spec:   spec,
+			Reason: fmt.Sprintf("Expected 5 to 7 fields, found %d", len(fields)),
+		}
 	}
 
 	// If a sixth field is not provided (DayOfWeek), then it is equivalent to star.
@@ -31,55 +96,251 @@ func Parse(spec string) Schedule {
 		fields = append(fields, "*")
 	}
 
-	schedule := &SpecSchedule{
-		Second: getField(fields[0], seconds),
-		Minute: getField(fields[1], minutes),
-		Hour:   getField(fields[2], hours),
-		Dom:    getField(fields[3], dom),
-		Month:  getField(fields[4], months),
-		Dow:    getField(fields[5], dow),
+	var (
+		schedule = &SpecSchedule{Location: loc}
+		targets  = []*uint64{
+			&schedule.Second,
+			&schedule.Minute,
+			&schedule.Hour,
+			&schedule.Dom,
+			&schedule.Month,
+			&schedule.Dow,
+		}
+	)
+	for i, f := range fieldNames {
+		var (
+			bits uint64
+			err  *ParseError
+		)
+		switch f.name {
+		case "day of month":
+			bits, err = getDayField(fields[i], f.bounds, schedule.domExtension)
+		case "day of week":
+			bits, err = getDayField(fields[i], f.bounds, schedule.dowExtension)
+		default:
+			bits, err = getField(fields[i], f.bounds)
+		}
+		if err != nil {
+			err.Spec, err.Field = spec, f.name
+			return nil, err
+		}
+		*targets[i] = bits
 	}
 
-	return schedule
+	if len(fields) == 7 {
+		var err *ParseError
+		if schedule.Year, err = getYears(fields[6]); err != nil {
+			err.Spec, err.Field = spec, "year"
+			return nil, err
+		}
+	}
+
+	return schedule, nil
+}
+
+// parseZone splits the time zone off the front of spec.
+func parseZone(spec string) (*time.Location, string, error) {
+	i := strings.IndexFunc(spec, unicode.IsSpace)
+	if i < 0 {
+		return nil, "", &ParseError{Spec: spec, Reason: "Missing spec after time zone"}
+	}
+	name := spec[strings.Index(spec, "=")+1 : i]
+	if name == "" {
+		return nil, "", &ParseError{Spec: spec, Reason: "Empty time zone"}
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, "", &ParseError{Spec: spec, Reason: "Unknown time zone " + name}
+	}
+	return loc, strings.TrimSpace(spec[i:]), nil
+}
+
+// fieldNames lists the fields of a spec in order, with their bounds.
+var fieldNames = []struct {
+	name   string
+	bounds bounds
+}{
+	{"second", seconds},
+	{"minute", minutes},
+	{"hour", hours},
+	{"day of month", dom},
+	{"month", months},
+	{"day of week", dow},
 }
 
 // getField returns an Int with the bits set representing all of the times that
 // the field represents.  A "field" is a comma-separated list of "ranges".
-func getField(field string, r bounds) uint64 {
+func getField(field string, r bounds) (uint64, *ParseError) {
 	// list = range {"," range}
 	var bits uint64
 	ranges := strings.FieldsFunc(field, func(r rune) bool { return r == ',' })
+	if len(ranges) == 0 {
+		return 0, rangeError(field, r, "Empty field")
+	}
 	for _, expr := range ranges {
-		bits |= getRange(expr, r)
+		b, err := getRange(expr, r)
+		if err != nil {
+			return 0, err
+		}
+		bits |= b
 	}
-	return bits
+	return bits, nil
+}
+
+// getDayField is getField for the day fields, whose ranges may also be Quartz
+// extensions, read into the schedule by ext. ext reports whether expr was
+// one.
+func getDayField(field string, r bounds, ext func(expr string) (bool, *ParseError)) (uint64, *ParseError) {
+	var bits uint64
+	ranges := strings.FieldsFunc(field, func(r rune) bool { return r == ',' })
+	if len(ranges) == 0 {
+		return 0, rangeError(field, r, "Empty field")
+	}
+	for _, expr := range ranges {
+		ok, err := ext(expr)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			continue
+		}
+		b, err := getRange(expr, r)
+		if err != nil {
+			return 0, err
+		}
+		bits |= b
+	}
+	return bits, nil
+}
+
+// domExtension reads "L", "LW" and "nW" in the day of month field.
+func (s *SpecSchedule) domExtension(expr string) (bool, *ParseError) {
+	switch upper := strings.ToUpper(expr); {
+	case upper == "L":
+		s.LastDom = true
+	case upper == "LW":
+		s.NearestWeekday |= 1
+	case strings.HasSuffix(upper, "W"):
+		day, err := parseInt(expr[:len(expr)-1])
+		if err != nil {
+			return false, rangeError(expr, dom, err.Error())
+		}
+		if day < dom.min || day > dom.max {
+			return false, rangeError(expr, dom, fmt.Sprintf("Day (%d) out of range", day))
+		}
+		s.NearestWeekday |= 1 << day
+	default:
+		return false, nil
+	}
+	return true, nil
+}
+
+// dowExtension reads "nL" and "n#k" in the day of week field.
+func (s *SpecSchedule) dowExtension(expr string) (bool, *ParseError) {
+	var (
+		weekday, nth uint
+		err          error
+	)
+	if i := strings.Index(expr, "#"); i >= 0 {
+		if weekday, err = parseIntOrName(expr[:i], dow.names); err != nil {
+			return false, rangeError(expr, dow, err.Error())
+		}
+		if nth, err = parseInt(expr[i+1:]); err != nil {
+			return false, rangeError(expr, dow, err.Error())
+		}
+		if nth < 1 || nth > 5 {
+			return false, rangeError(expr, dow, fmt.Sprintf("Week of month (%d) should be 1 to 5", nth))
+		}
+	} else if len(expr) > 1 && strings.ToUpper(expr[len(expr)-1:]) == "L" {
+		if weekday, err = parseIntOrName(expr[:len(expr)-1], dow.names); err != nil {
+			return false, rangeError(expr, dow, err.Error())
+		}
+	} else {
+		return false, nil
+	}
+	if weekday > dow.max {
+		return false, rangeError(expr, dow, fmt.Sprintf("Day of week (%d) above maximum (%d)", weekday, dow.max))
+	}
+	if nth > 0 {
+		s.NthDow[nth-1] |= 1 << weekday
+	} else {
+		s.LastDow |= 1 << weekday
+	}
+	return true, nil
+}
+
+// getYears returns the years the year field lists, in order, or nil if it
+// lists them all.
+func getYears(field string) ([]int, *ParseError) {
+	listed := make([]bool, years.max-years.min+1)
+	ranges := strings.FieldsFunc(field, func(r rune) bool { return r == ',' })
+	if len(ranges) == 0 {
+		return nil, rangeError(field, years, "Empty field")
+	}
+	for _, expr := range ranges {
+		start, end, step, star, err := parseRange(expr, years)
+		if err != nil {
+			return nil, err
+		}
+		if star && step == 1 {
+			return nil, nil
+		}
+		for y := start; y <= end; y += step {
+			listed[y-years.min] = true
+		}
+	}
+	var list []int
+	for i, ok := range listed {
+		if ok {
+			list = append(list, int(years.min)+i)
+		}
+	}
+	return list, nil
 }
 
 // getRange returns the bits indicated by the given expression:
 //   number | number "-" number [ "/" number ]
-func getRange(expr string, r bounds) uint64 {
+func getRange(expr string, r bounds) (uint64, *ParseError) {
+	start, end, step, star, err := parseRange(expr, r)
+	if err != nil {
+		return 0, err
+	}
+	var extra_star uint64
+	if star {
+		extra_star = starBit
+	}
+	return getBits(start, end, step) | extra_star, nil
+}
 
+// parseRange reads the start, end and step of the given expression, as
+// getRange takes it, and whether it is a star.
+func parseRange(expr string, r bounds) (start, end, step uint, star bool, perr *ParseError) {
 	var (
-		start, end, step uint
-		rangeAndStep     = strings.Split(expr, "/")
-		lowAndHigh       = strings.Split(rangeAndStep[0], "-")
-		singleDigit      = len(lowAndHigh) == 1
+		rangeAndStep = strings.Split(expr, "/")
+		lowAndHigh   = strings.Split(rangeAndStep[0], "-")
+		singleDigit  = len(lowAndHigh) == 1
+		err          error
 	)
 
-	var extra_star uint64
 	if lowAndHigh[0] == "*" || lowAndHigh[0] == "?" {
 		start = r.min
 		end = r.max
-		extra_star = starBit
+		star = true
 	} else {
-		start = parseIntOrName(lowAndHigh[0], r.names)
+		start, err = parseIntOrName(lowAndHigh[0], r.names)
+		if err != nil {
+			return 0, 0, 0, false, rangeError(expr, r, err.Error())
+		}
 		switch len(lowAndHigh) {
 		case 1:
 			end = start
 		case 2:
-			end = parseIntOrName(lowAndHigh[1], r.names)
+			end, err = parseIntOrName(lowAndHigh[1], r.names)
+			if err != nil {
+				return 0, 0, 0, false, rangeError(expr, r, err.Error())
+			}
 		default:
-			log.Panicf("Too many hyphens: %s", expr)
+			return 0, 0, 0, false, rangeError(expr, r, "Too many hyphens")
 		}
 	}
 
@@ -87,50 +348,61 @@ func getRange(expr string, r bounds) uint64 {
 	case 1:
 		step = 1
 	case 2:
-		step = mustParseInt(rangeAndStep[1])
+		step, err = parseInt(rangeAndStep[1])
+		if err != nil {
+			return 0, 0, 0, false, rangeError(expr, r, err.Error())
+		}
+		if step == 0 {
+			return 0, 0, 0, false, rangeError(expr, r, "Step of range should be a positive number")
+		}
 
 		// Special handling: "N/step" means "N-max/step".
 		if singleDigit {
 			end = r.max
 		}
 	default:
-		log.Panicf("Too many slashes: %s", expr)
+		return 0, 0, 0, false, rangeError(expr, r, "Too many slashes")
 	}
 
 	if start < r.min {
-		log.Panicf("Beginning of range (%d) below minimum (%d): %s", start, r.min, expr)
+		return 0, 0, 0, false, rangeError(expr, r, fmt.Sprintf("Beginning of range (%d) below minimum (%d)", start, r.min))
 	}
 	if end > r.max {
-		log.Panicf("End of range (%d) above maximum (%d): %s", end, r.max, expr)
+		return 0, 0, 0, false, rangeError(expr, r, fmt.Sprintf("End of range (%d) above maximum (%d)", end, r.max))
 	}
 	if start > end {
-		log.Panicf("Beginning of range (%d) beyond end of range (%d): %s", start, end, expr)
+		return 0, 0, 0, false, rangeError(expr, r, fmt.Sprintf("Beginning of range (%d) beyond end of range (%d)", start, end))
 	}
 
-	return getBits(start, end, step) | extra_star
+	return start, end, step, star, nil
+}
+
+// rangeError returns a ParseError for expr; the caller fills in the field.
+func rangeError(expr string, r bounds, reason string) *ParseError {
+	return &ParseError{Token: expr, Min: r.min, Max: r.max, Reason: reason}
 }
 
 // parseIntOrName returns the (possibly-named) integer contained in expr.
-func parseIntOrName(expr string, names map[string]uint) uint {
+func parseIntOrName(expr string, names map[string]uint) (uint, error) {
 	if names != nil {
 		if namedInt, ok := names[strings.ToLower(expr)]; ok {
-			return namedInt
+			return namedInt, nil
 		}
 	}
-	return mustParseInt(expr)
+	return parseInt(expr)
 }
 
-// mustParseInt parses the given expression as an int or panics.
-func mustParseInt(expr string) uint {
+// parseInt parses the given expression as a non-negative int.
+func parseInt(expr string) (uint, error) {
 	num, err := strconv.Atoi(expr)
 	if err != nil {
-		log.Panicf("Failed to parse int from %s: %s", expr, err)
+		return 0, fmt.Errorf("Failed to parse int from %s: %s", expr, err)
 	}
 	if num < 0 {
-		log.Panicf("Negative number (%d) not allowed: %s", num, expr)
+		return 0, fmt.Errorf("Negative number (%d) not allowed: %s", num, expr)
 	}
 
-	return uint(num)
+	return uint(num), nil
 }
 
 // getBits sets all bits in the range [min, max], modulo the given step size.
@@ -154,9 +426,9 @@ func all(r bounds) uint64 {
 	return getBits(r.min, r.max, 1) | starBit
 }
 
-// parseDescriptor returns a pre-defined schedule for the expression, or panics
-// if none matches.
-func parseDescriptor(spec string) Schedule {
+// parseDescriptor returns a pre-defined schedule for the expression, or an
+// error if none matches.
+func parseDescriptor(spec string) (Schedule, error) {
 	switch spec {
 	case "@yearly", "@annually":
 		return &SpecSchedule{
@@ -166,7 +438,7 @@ func parseDescriptor(spec string) Schedule {
 			Dom:    1 << dom.min,
 			Month:  1 << months.min,
 			Dow:    all(dow),
-		}
+		}, nil
 
 	case "@monthly":
 		return &SpecSchedule{
@@ -176,7 +448,7 @@ func parseDescriptor(spec string) Schedule {
 			Dom:    1 << dom.min,
 			Month:  all(months),
 			Dow:    all(dow),
-		}
+		}, nil
 
 	case "@weekly":
 		return &SpecSchedule{
@@ -186,7 +458,7 @@ func parseDescriptor(spec string) Schedule {
 			Dom:    all(dom),
 			Month:  all(months),
 			Dow:    1 << dow.min,
-		}
+		}, nil
 
 	case "@daily", "@midnight":
 		return &SpecSchedule{
@@ -196,7 +468,7 @@ func parseDescriptor(spec string) Schedule {
 			Dom:    all(dom),
 			Month:  all(months),
 			Dow:    all(dow),
-		}
+		}, nil
 
 	case "@hourly":
 		return &SpecSchedule{
@@ -206,18 +478,20 @@ func parseDescriptor(spec string) Schedule {
 			Dom:    all(dom),
 			Month:  all(months),
 			Dow:    all(dow),
-		}
+		}, nil
 	}
 
 	const every = "@every "
 	if strings.HasPrefix(spec, every) {
 		duration, err := time.ParseDuration(spec[len(every):])
 		if err != nil {
-			log.Panicf("Failed to parse duration %s: %s", spec, err)
+			return nil, &ParseError{Spec: spec, Reason: "Failed to parse duration: " + err.Error()}
+		}
+		if duration < time.Second {
+			return nil, &ParseError{Spec: spec, Reason: "Delays of less than a second are not supported"}
 		}
-		return Every(duration)
+		return Every(duration), nil
 	}
 
-	log.Panicf("Unrecognized descriptor: %s", spec)
-	return nil
+	return nil, &ParseError{Spec: spec, Reason: "Unrecognized descriptor"}
 }
@@ -30,7 +30,8 @@ func TestRange(t *testing.T) {
 	}
 	Convey("Test Range should be equal.", t, func() {
 		for _, c := range ranges {
-			actual := getRange(c.expr, bounds{c.min, c.max, nil})
+			actual, err := getRange(c.expr, bounds{c.min, c.max, nil})
+			So(err, ShouldBeNil)
 			So(c.expected, ShouldResemble, actual)
 		}
 	})
@@ -49,7 +50,8 @@ func TestField(t *testing.T) {
 	}
 	Convey("Test Field should be equal.", t, func() {
 		for _, c := range fields {
-			actual := getField(c.expr, bounds{c.min, c.max, nil})
+			actual, err := getField(c.expr, bounds{c.min, c.max, nil})
+			So(err, ShouldBeNil)
 			So(c.expected, ShouldResemble, actual)
 		}
 	})
@@ -98,7 +100,13 @@ func TestSpecSchedule(t *testing.T) {
 		expr     string
 		expected Schedule
 	}{
-		{"* 5 * * * *", &SpecSchedule{all(seconds), 1 << 5, all(hours), all(dom), all(months), all(dow)}},
+		{"* 5 * * * *", &SpecSchedule{Second: all(seconds), Minute: 1 << 5, Hour: all(hours), Dom: all(dom), Month: all(months), Dow: all(dow)}},
+		{"TZ=UTC * 5 * * * *", &SpecSchedule{Second: all(seconds), Minute: 1 << 5, Hour: all(hours), Dom: all(dom), Month: all(months), Dow: all(dow), Location: time.UTC}},
+		{"0 0 0 L,15W * 5L,2#2", &SpecSchedule{Second: 1, Minute: 1, Hour: 1, Month: all(months),
+			LastDom: true, NearestWeekday: 1 << 15, LastDow: 1 << 5, NthDow: [5]uint64{1: 1 << 2}}},
+		{"0 0 0 1 1 ? 2027-2030,2040/20", &SpecSchedule{Second: 1, Minute: 1, Hour: 1, Dom: 1 << 1, Month: 1 << 1, Dow: all(dow),
+			Year: []int{2027, 2028, 2029, 2030, 2040, 2060, 2080}}},
+		{"* 5 * * * * *", &SpecSchedule{Second: all(seconds), Minute: 1 << 5, Hour: all(hours), Dom: all(dom), Month: all(months), Dow: all(dow)}},
 		{"@every 5m", ConstantDelaySchedule{time.Duration(5) * time.Minute}},
 	}
 	Convey("Test SpecSchedule.", t, func() {
@@ -108,3 +116,48 @@ func TestSpecSchedule(t *testing.T) {
 		}
 	})
 }
+
+func TestParseSpecErrors(t *testing.T) {
+	errs := []struct {
+		spec     string
+		expected ParseError
+	}{
+		{"", ParseError{Reason: "Empty spec"}},
+		{"* * *", ParseError{Spec: "* * *", Reason: "Expected 5 to 7 fields, found 3"}},
+		{"* * * * * * * *", ParseError{Spec: "* * * * * * * *", Reason: "Expected 5 to 7 fields, found 8"}},
+		{"0 0 0 1 1 ? 2100", ParseError{Field: "year", Token: "2100", Min: 1970, Max: 2099}},
+		{"0 0 0 1 1 ? 2030-2027", ParseError{Field: "year", Token: "2030-2027", Min: 1970, Max: 2099}},
+		{"0 75 * * *", ParseError{Field: "minute", Token: "75", Max: 59}},
+		{"0 0 0 0 * ?", ParseError{Field: "day of month", Token: "0", Min: 1, Max: 31}},
+		{"0 0 0 * Foo ?", ParseError{Field: "month", Token: "Foo", Min: 1, Max: 12}},
+		{"0 0 20-10 * * ?", ParseError{Field: "hour", Token: "20-10", Max: 23}},
+		{"0 0 1-2-3 * * ?", ParseError{Field: "hour", Token: "1-2-3", Max: 23}},
+		{"*/0 * * * * ?", ParseError{Field: "second", Token: "*/0", Max: 59}},
+		{"0 0 0 * * ,", ParseError{Field: "day of week", Token: ",", Max: 6}},
+		{"0 0 0 32W * ?", ParseError{Field: "day of month", Token: "32W", Min: 1, Max: 31}},
+		{"0 0 0 ? * 2#6", ParseError{Field: "day of week", Token: "2#6", Max: 6}},
+		{"0 0 0 ? * 7L", ParseError{Field: "day of week", Token: "7L", Max: 6}},
+		{"@sometimes", ParseError{Spec: "@sometimes", Reason: "Unrecognized descriptor"}},
+		{"@every 10ms", ParseError{Spec: "@every 10ms", Reason: "Delays of less than a second are not supported"}},
+		{"CRON_TZ=Mars/Olympus 0 0 9 * * *", ParseError{Spec: "CRON_TZ=Mars/Olympus 0 0 9 * * *", Reason: "Unknown time zone Mars/Olympus"}},
+		{"TZ= 0 0 9 * * *", ParseError{Spec: "TZ= 0 0 9 * * *", Reason: "Empty time zone"}},
+		{"TZ=UTC", ParseError{Spec: "TZ=UTC", Reason: "Missing spec after time zone"}},
+		{"TZ=UTC 0 75 * * *", ParseError{Field: "minute", Token: "75", Max: 59}},
+	}
+	Convey("Invalid specs are reported with the field, token and bounds.", t, func() {
+		for _, c := range errs {
+			_, err := ParseSpec(c.spec)
+			So(err, ShouldHaveSameTypeAs, &ParseError{})
+			actual := *err.(*ParseError)
+			if c.expected.Field != "" {
+				// The reason is only informative for field errors.
+				actual.Spec, actual.Reason = "", ""
+			}
+			So(actual, ShouldResemble, c.expected)
+		}
+	})
+
+	Convey("Parse still panics on invalid specs.", t, func() {
+		So(func() { Parse("0 75 * * *") }, ShouldPanic)
+	})
+}
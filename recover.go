@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+)
+
+// RecoverPolicy decides what happens to a once job whose fire time passed
+// while the process was down.
+type RecoverPolicy int
+
+const (
+	// RecoverSkip drops once jobs that are already overdue.
+	RecoverSkip RecoverPolicy = iota
+
+	// RecoverFire has overdue once jobs count as missed, so that they are
+	// run as their Misfire says as soon as the scheduler starts.
+	RecoverFire
+)
+
+// onceLayout is how the fire time of a once job is kept in Bean.Schedule.
+const onceLayout = time.RFC3339Nano
+
+// Replay reads the journal written to filename and registers every job it
+// describes on c under its original id, as of its last update, leaving out
+// deleted jobs, once and now jobs that already ran, and keeping paused ones
+// paused. Activations of cron jobs since their last run, or their last
+// update, count as missed, as their Misfire says. Retries still due for a
+// job are scheduled again. It must be called before c is started.
+// A missing journal is not an error; malformed lines are logged and skipped.
+// It returns the number of jobs put back on c.
+func Replay(filename string, c *Cron, policy RecoverPolicy) (int, error) {
+	var (
+		beans    = make(map[int64]Bean)
+		paused   = make(map[int64]bool)
+		attempts = make(map[int64][]Bean)
+		order    []int64
+	)
+	err := ReadJson(filename, func(data []byte) error {
+		var b Bean
+		if err := json.Unmarshal(data, &b); err != nil {
+			return err
+		}
+		switch b.Method {
+		case "del":
+			delete(beans, b.Id)
+			delete(paused, b.Id)
+		case "pause":
+			paused[b.Id] = true
+		case "resume":
+			delete(paused, b.Id)
+		case "attempt":
+			// A first attempt starts a new run of the job.
+			if b.Attempt <= 1 {
+				attempts[b.Id] = nil
+			}
+			attempts[b.Id] = append(attempts[b.Id], b)
+		case "update":
+			// An update carries the whole new state of the job.
+			if old, ok := beans[b.Id]; ok {
+				b.Method = old.Method
+				beans[b.Id] = b
+			}
+		default:
+			if _, ok := beans[b.Id]; !ok {
+				order = append(order, b.Id)
+			}
+			beans[b.Id] = b
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	restored := 0
+	for _, id := range order {
+		b, ok := beans[id]
+		if !ok {
+			continue
+		}
+		if b.Method != "cron" && len(attempts[id]) > 0 {
+			// It ran; a retry of it still to come is put back below.
+			continue
+		}
+		schedule, err := beanSchedule(b, now, policy)
+		if err != nil {
+			log.Printf("journal %s: job %d: %s", filename, id, err)
+			continue
+		}
+		if schedule == nil {
+			continue
+		}
+		opts := []EntryOption{WithOverlap(b.Overlap), WithMisfire(b.Misfire)}
+		if b.Method == "cron" {
+			since := b.Time
+			if records := attempts[id]; len(records) > 0 && records[len(records)-1].Time.After(since) {
+				since = records[len(records)-1].Time
+			}
+			opts = append(opts, WithMissedSince(since))
+		} else {
+			opts = append(opts, WithMissedSince(dueSince(b)))
+		}
+		c.Restore(id, schedule, beanJob(c, b), opts...)
+		if paused[id] {
+			c.PauseJob(id)
+		}
+		restored++
+	}
+
+	// Put back the retries that were still to come.
+	for id, records := range attempts {
+		b, ok := beans[id]
+		last := records[len(records)-1]
+		if !ok || last.Schedule == "" {
+			continue
+		}
+		at := b
+		at.Method, at.Schedule = "retry", last.Schedule
+		schedule, err := beanSchedule(at, now, policy)
+		if err != nil {
+			log.Printf("journal %s: retry of job %d: %s", filename, id, err)
+			continue
+		}
+		if schedule == nil {
+			continue
+		}
+		var history []Attempt
+		for _, r := range records {
+			history = append(history, Attempt{Attempt: r.Attempt, Time: r.Time, Status: r.Status, Error: r.Error})
+		}
+		c.Schedule(schedule, beanJob(c, b).retry(id, history),
+			WithOverlap(b.Overlap), WithMisfire(b.Misfire), WithMissedSince(dueSince(at)), WithOrigin(id))
+	}
+	return restored, nil
+}
+
+// beanSchedule rebuilds the Schedule of a journaled job. It returns a nil
+// Schedule for jobs that should not be put back.
+func beanSchedule(b Bean, now time.Time, policy RecoverPolicy) (Schedule, error) {
+	switch b.Method {
+	case "cron":
+		return ParseSpec(b.Schedule)
+
+	case "once", "now", "retry":
+		at, err := time.Parse(onceLayout, b.Schedule)
+		if err != nil {
+			return nil, err
+		}
+		if at.After(now) || policy == RecoverFire {
+			return &OnceSchedule{thetime: at}, nil
+		}
+		return nil, nil
+	}
+	return nil, errors.New("unknown method " + b.Method)
+}
+
+// dueSince is when the once job, now job or retry b is counted from as the
+// Cron starts: just before its fire time, so that it is due then however
+// late the Cron starts, and run as its Misfire says if that is past.
+func dueSince(b Bean) time.Time {
+	at, _ := time.Parse(onceLayout, b.Schedule)
+	return at.Add(-time.Nanosecond)
+}
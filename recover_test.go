@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func writeJournal(beans ...Bean) string {
+	f, err := ioutil.TempFile("", "journal")
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	for _, b := range beans {
+		line, _ := json.Marshal(b)
+		f.Write(line)
+		f.WriteString("\r\n")
+	}
+	return f.Name()
+}
+
+func TestReplay(t *testing.T) {
+	now := time.Now()
+	journal := writeJournal(
+		Bean{Id: 10, Time: now, Method: "cron", Url: "http://a", Schedule: "0 0 * * * ?"},
+		Bean{Id: 11, Time: now, Method: "once", Url: "http://b", Schedule: now.Add(time.Hour).Format(onceLayout)},
+		Bean{Id: 12, Time: now, Method: "once", Url: "http://c", Schedule: now.Add(-time.Hour).Format(onceLayout)},
+		Bean{Id: 13, Time: now, Method: "cron", Url: "http://d", Schedule: "not a spec"},
+	)
+	defer os.Remove(journal)
+
+	Convey("Replay restores jobs under their original ids.", t, func() {
+		cron := New()
+		n, err := Replay(journal, cron, RecoverSkip)
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, 2)
+		So(cron.entries[0].Id, ShouldEqual, 10)
+		So(cron.entries[1].Id, ShouldEqual, 11)
+		So(cron.AddFunc("@hourly", func(id int64) {}), ShouldBeGreaterThan, 11)
+	})
+
+	Convey("Overdue once jobs are fired when the policy says so, however late the scheduler starts.", t, func() {
+		cron := New(WithClock(newFakeClock(now.Add(2 * time.Second))))
+		n, err := Replay(journal, cron, RecoverFire)
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, 3)
+		So(cron.entries[2].Id, ShouldEqual, 12)
+
+		ran := make(chan int64, 1)
+		cron.UpdateJob(12, nil, FuncJob(func(id int64) { ran <- id }))
+		cron.Start()
+		defer cron.Stop()
+		select {
+		case id := <-ran:
+			So(id, ShouldEqual, 12)
+		case <-time.After(time.Second):
+			t.Fatal("the overdue job did not run")
+		}
+	})
+
+	Convey("A missing journal restores nothing.", t, func() {
+		n, err := Replay(journal+".missing", New(), RecoverSkip)
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, 0)
+	})
+}
+
+func TestReplayRan(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Hour)
+	journal := writeJournal(
+		Bean{Id: 1, Time: past, Method: "now", Url: "http://a", Schedule: past.Format(onceLayout)},
+		Bean{Id: 1, Time: past, Method: "attempt", Attempt: 1, Status: 200},
+		Bean{Id: 2, Time: past, Method: "once", Url: "http://b", Schedule: past.Format(onceLayout)},
+		Bean{Id: 2, Time: past, Method: "attempt", Attempt: 1, Status: 500, Schedule: now.Add(time.Minute).Format(onceLayout)},
+		Bean{Id: 3, Time: past, Method: "now", Url: "http://c", Schedule: past.Format(onceLayout)},
+	)
+	defer os.Remove(journal)
+
+	Convey("Once and now jobs that ran are not run again, only their retries still to come.", t, func() {
+		cron := New()
+		n, err := Replay(journal, cron, RecoverFire)
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, 1)
+		So(len(cron.entries), ShouldEqual, 2)
+		So(cron.entries[0].Id, ShouldEqual, 3)
+		So(cron.entries[1].Job.(*CallJob).origin, ShouldEqual, 2)
+	})
+}
+
+func readJournal(filename string) []Bean {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		panic(err)
+	}
+	var beans []Bean
+	for _, line := range strings.Split(string(data), "\r\n") {
+		var b Bean
+		if json.Unmarshal([]byte(line), &b) == nil {
+			beans = append(beans, b)
+		}
+	}
+	return beans
+}
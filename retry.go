@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Retry says how often, and how soon, a failed callback is tried again.
+type Retry struct {
+	// The most times the callback is made, the first one included.
+	Attempts int
+
+	// The delay before the first retry. Every later retry waits Multiplier
+	// times longer than the one before; the multiplier is 2 when not set.
+	Backoff    time.Duration
+	Multiplier float64 `json:",omitempty"`
+
+	// Every delay is moved by up to this fraction of itself, either way, so
+	// that jobs failing together do not retry together. Between 0 and 1.
+	Jitter float64 `json:",omitempty"`
+
+	// The response codes worth retrying. When empty, 408, 429 and any 5xx
+	// are. Errors that come without a response are always retried.
+	Status []int `json:",omitempty"`
+}
+
+// DefaultBackoff is the delay before the first retry when Backoff is not set.
+const DefaultBackoff = time.Second
+
+// check reports what is wrong with r, if anything.
+func (r *Retry) check() error {
+	switch {
+	case r.Attempts < 1:
+		return errors.New("retry should be at least 1")
+	case r.Backoff != 0 && r.Backoff < time.Second:
+		return errors.New("backoff should be at least 1s")
+	case r.Multiplier != 0 && r.Multiplier < 1:
+		return errors.New("multiplier should be at least 1")
+	case r.Jitter < 0 || r.Jitter > 1:
+		return errors.New("jitter should be between 0 and 1")
+	}
+	for _, code := range r.Status {
+		if code < 100 || code > 599 {
+			return fmt.Errorf("bad status %d", code)
+		}
+	}
+	return nil
+}
+
+// retryable reports whether attempt, which failed with err, should be
+// followed by another one.
+func (r *Retry) retryable(attempt int, err error) bool {
+	if r == nil || err == nil || attempt >= r.Attempts {
+		return false
+	}
+	se, ok := err.(*StatusError)
+	if !ok {
+		return true
+	}
+	if len(r.Status) == 0 {
+		return se.Code == 408 || se.Code == 429 || se.Code >= 500
+	}
+	for _, code := range r.Status {
+		if code == se.Code {
+			return true
+		}
+	}
+	return false
+}
+
+// delay returns how long to wait after the given failed attempt.
+func (r *Retry) delay(attempt int) time.Duration {
+	backoff, multiplier := r.Backoff, r.Multiplier
+	if backoff <= 0 {
+		backoff = DefaultBackoff
+	}
+	if multiplier < 1 {
+		multiplier = 2
+	}
+	d := float64(backoff) * math.Pow(multiplier, float64(attempt-1))
+	if r.Jitter > 0 {
+		d += d * r.Jitter * (2*rand.Float64() - 1)
+	}
+	if d > math.MaxInt64 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(d)
+}
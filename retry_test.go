@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	Convey("Failures are retried while attempts are left.", t, func() {
+		r := &Retry{Attempts: 3}
+		So(r.retryable(1, errors.New("refused")), ShouldBeTrue)
+		So(r.retryable(2, &StatusError{503}), ShouldBeTrue)
+		So(r.retryable(3, &StatusError{503}), ShouldBeFalse)
+		So(r.retryable(1, nil), ShouldBeFalse)
+		So((*Retry)(nil).retryable(1, errors.New("refused")), ShouldBeFalse)
+	})
+
+	Convey("Only the listed status codes are retried.", t, func() {
+		So((&Retry{Attempts: 3}).retryable(1, &StatusError{404}), ShouldBeFalse)
+		So((&Retry{Attempts: 3}).retryable(1, &StatusError{429}), ShouldBeTrue)
+		r := &Retry{Attempts: 3, Status: []int{404}}
+		So(r.retryable(1, &StatusError{404}), ShouldBeTrue)
+		So(r.retryable(1, &StatusError{500}), ShouldBeFalse)
+	})
+
+	Convey("Delays grow by the multiplier, within the jitter.", t, func() {
+		r := &Retry{Backoff: time.Second, Multiplier: 3}
+		So(r.delay(1), ShouldEqual, time.Second)
+		So(r.delay(3), ShouldEqual, 9*time.Second)
+		So((&Retry{}).delay(2), ShouldEqual, 2*DefaultBackoff)
+
+		r.Jitter = 0.5
+		for i := 0; i < 100; i++ {
+			So(r.delay(2), ShouldBeBetweenOrEqual, 1500*time.Millisecond, 4500*time.Millisecond)
+		}
+	})
+}
+
+func TestRetryJob(t *testing.T) {
+	defer testMain()()
+	MainCron.Start()
+	defer MainCron.Stop()
+
+	var hits int32
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer target.Close()
+
+	Convey("A failing callback is retried through once entries until it works.", t, func() {
+		bean := Bean{Method: "now", Url: target.URL, Retry: &Retry{Attempts: 5, Backoff: 100 * time.Millisecond, Multiplier: 1}}
+		MainCron.AddNowjob(beanJob(MainCron, bean))
+		time.Sleep(ONE_SECOND)
+		So(atomic.LoadInt32(&hits), ShouldEqual, 3)
+		So(len(MainCron.Entries()), ShouldEqual, 0)
+
+		attempts := 0
+		cron := New()
+		Replay(databk.logfile.Name(), cron, RecoverSkip)
+		So(len(cron.entries), ShouldEqual, 0)
+		for _, b := range readJournal(databk.logfile.Name()) {
+			if b.Method == "attempt" {
+				attempts++
+				So(b.Attempt, ShouldEqual, attempts)
+			}
+		}
+		So(attempts, ShouldEqual, 3)
+	})
+
+	Convey("A pending retry survives a restart.", t, func() {
+		bean := Bean{Method: "now", Url: target.URL + "/gone", Retry: &Retry{Attempts: 2, Backoff: time.Hour}}
+		atomic.StoreInt32(&hits, 0)
+		bean.Id = MainCron.AddNowjob(beanJob(MainCron, bean))
+		journal(bean)
+		time.Sleep(100 * time.Millisecond)
+		So(atomic.LoadInt32(&hits), ShouldEqual, 1)
+		So(jobInfo(MainCron.Entries()[0]).Method, ShouldEqual, "retry")
+
+		cron := New()
+		Replay(databk.logfile.Name(), cron, RecoverSkip)
+		So(len(cron.entries), ShouldEqual, 1)
+		So(cron.entries[0].Job.(*CallJob).attempt, ShouldEqual, 2)
+		So(len(cron.entries[0].Job.(*CallJob).history), ShouldEqual, 1)
+	})
+}
+
+func TestDeletedJob(t *testing.T) {
+	defer testMain()()
+	MainCron.Start()
+	defer MainCron.Stop()
+
+	called := make(chan bool, 1)
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called <- true
+		<-r.Context().Done()
+	}))
+	defer target.Close()
+
+	Convey("A job deleted during its call gives up, without retry or dead letter.", t, func() {
+		bean := Bean{Method: "now", Url: target.URL, Retry: &Retry{Attempts: 3, Backoff: time.Hour}}
+		id := MainCron.AddNowjob(beanJob(MainCron, bean))
+		<-called
+		So(MainCron.DelJob(id), ShouldBeTrue)
+		for i := 0; i < 100 && len(history.Runs(id)) == 0; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		runs := history.Runs(id)
+		So(len(runs), ShouldEqual, 1)
+		So(runs[0].Error, ShouldContainSubstring, ErrJobDeleted.Error())
+		So(len(MainCron.Entries()), ShouldEqual, 0)
+		So(len(deadletters.List()), ShouldEqual, 0)
+	})
+}
+
+func TestDeletedRetry(t *testing.T) {
+	defer testMain()()
+	MainCron.Start()
+	defer MainCron.Stop()
+
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer target.Close()
+
+	// failing adds a job that fails once, and returns its id and that of the
+	// retry it leaves pending.
+	failing := func() (int64, int64) {
+		bean := Bean{Method: "now", Url: target.URL, Retry: &Retry{Attempts: 3, Backoff: time.Hour}}
+		bean.Id = MainCron.AddNowjob(beanJob(MainCron, bean))
+		journal(bean)
+		var retry int64
+		So(waitFor(func() bool {
+			entries := MainCron.Entries()
+			if len(entries) == 1 && entries[0].Origin == bean.Id {
+				retry = entries[0].Id
+			}
+			return retry != 0
+		}), ShouldBeTrue)
+		return bean.Id, retry
+	}
+	restored := func() int {
+		cron := New()
+		Replay(databk.logfile.Name(), cron, RecoverFire)
+		return len(cron.entries)
+	}
+
+	Convey("Deleting a job takes its pending retry with it, for good.", t, func() {
+		id, _ := failing()
+		code, _ := jobRequest("DELETE", "/jobs/"+strconv.FormatInt(id, 10))
+		So(code, ShouldEqual, http.StatusOK)
+		So(len(MainCron.Entries()), ShouldEqual, 0)
+		So(restored(), ShouldEqual, 0)
+	})
+
+	Convey("Deleting the pending retry ends the retries of its job, for good.", t, func() {
+		_, retry := failing()
+		code, _ := jobRequest("DELETE", "/jobs/"+strconv.FormatInt(retry, 10))
+		So(code, ShouldEqual, http.StatusOK)
+		So(len(MainCron.Entries()), ShouldEqual, 0)
+		So(restored(), ShouldEqual, 0)
+	})
+}
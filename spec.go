@@ -1,13 +1,36 @@
 package main
 
 import (
+	"sort"
 	"time"
 )
 
 // SpecSchedule specifies a duty cycle (to the second granularity), based on a
 // traditional crontab specification. It is computed initially and stored as bit sets.
+//
+// The fields are read off the wall clock of Location, or of the time given to
+// Next when Location is nil. A time the clock skips, as daylight saving time
+// starts, never comes, so an activation at that time is missed; a time the
+// clock reads twice, as it ends, activates the schedule both times.
 type SpecSchedule struct {
 	Second, Minute, Hour, Dom, Month, Dow uint64
+
+	// The Quartz extensions of the day fields, days matching them match Dom
+	// or Dow as well: "L", the last day of the month; "nW", the weekday
+	// nearest day n without leaving the month, as bit n of NearestWeekday,
+	// bit 0 standing for "LW", the last weekday of the month; "nL", the last
+	// day of week n of the month, as bit n of LastDow; and "n#k", the k-th
+	// day of week n of the month, as bit n of NthDow[k-1].
+	LastDom        bool
+	NearestWeekday uint64
+	LastDow        uint64
+	NthDow         [5]uint64
+
+	// Year lists the years the schedule is activated in, in order, or is nil
+	// for every year up to the last one a spec may give.
+	Year []int
+
+	Location *time.Location
 }
 
 // bounds provides a range of acceptable values (plus a map of name to value).
@@ -45,6 +68,7 @@ var (
 		"fri": 5,
 		"sat": 6,
 	}}
+	years = bounds{1970, 2099, nil}
 )
 
 const (
@@ -54,6 +78,7 @@ const (
 
 // Next returns the next time this schedule is activated, greater than the given
 // time.  If no time can be found to satisfy the schedule, return the zero time.
+// The time returned is in the location of the given time.
 func (s *SpecSchedule) Next(t time.Time) time.Time {
 	// General approach:
 	// For Month, Day, Hour, Minute, Second:
@@ -63,20 +88,35 @@ func (s *SpecSchedule) Next(t time.Time) time.Time {
 	// of the field list (since it is necessary to re-verify previous field
 	// values)
 
+	// Work on the clock of the schedule's own location, if it has one.
+	origLocation := t.Location()
+	if s.Location != nil {
+		t = t.In(s.Location)
+	}
+
 	// Start at the earliest possible time (the upcoming second).
 	t = t.Add(1*time.Second - time.Duration(t.Nanosecond())*time.Nanosecond)
 
 	// This flag indicates whether a field has been incremented.
 	added := false
 
-	// If no time is found within five years, return zero.
-	yearLimit := t.Year() + 5
+	// If no time is found by the end of the last year, return zero.
+	lastYear := int(years.max)
+	if len(s.Year) > 0 {
+		lastYear = s.Year[len(s.Year)-1]
+	}
 
 WRAP:
-	if t.Year() > yearLimit {
+	if t.Year() > lastYear {
 		return time.Time{}
 	}
 
+	// Skip to the first applicable year.
+	if i := sort.SearchInts(s.Year, t.Year()); i < len(s.Year) && s.Year[i] > t.Year() {
+		added = true
+		t = dayStart(s.Year[i], time.January, 1, t.Location())
+	}
+
 	// Find the first applicable month.
 	// If it's this month, then do nothing.
 	for 1<<uint(t.Month())&s.Month == 0 {
@@ -84,9 +124,9 @@ WRAP:
 		if !added {
 			added = true
 			// Otherwise, set the date at the beginning (since the current time is irrelevant).
-			t = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+			t = dayStart(t.Year(), t.Month(), 1, t.Location())
 		}
-		t = t.AddDate(0, 1, 0)
+		t = dayStart(t.Year(), t.Month()+1, 1, t.Location())
 
 		// Wrapped around.
 		if t.Month() == time.January {
@@ -98,9 +138,9 @@ WRAP:
 	for !dayMatches(s, t) {
 		if !added {
 			added = true
-			t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+			t = dayStart(t.Year(), t.Month(), t.Day(), t.Location())
 		}
-		t = t.AddDate(0, 0, 1)
+		t = dayStart(t.Year(), t.Month(), t.Day()+1, t.Location())
 
 		if t.Day() == 1 {
 			goto WRAP
@@ -110,7 +150,10 @@ WRAP:
 	for 1<<uint(t.Hour())&s.Hour == 0 {
 		if !added {
 			added = true
-			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+			// Go back to the start of the hour rather than setting the
+			// clock, which might pick the other reading of an hour that
+			// comes twice.
+			t = t.Add(-time.Duration(t.Minute())*time.Minute - time.Duration(t.Second())*time.Second)
 		}
 		t = t.Add(1 * time.Hour)
 
@@ -122,7 +165,7 @@ WRAP:
 	for 1<<uint(t.Minute())&s.Minute == 0 {
 		if !added {
 			added = true
-			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, t.Location())
+			t = t.Add(-time.Duration(t.Second()) * time.Second)
 		}
 		t = t.Add(1 * time.Minute)
 
@@ -134,7 +177,7 @@ WRAP:
 	for 1<<uint(t.Second())&s.Second == 0 {
 		if !added {
 			added = true
-			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, t.Location())
+			t = t.Truncate(time.Second)
 		}
 		t = t.Add(1 * time.Second)
 
@@ -143,6 +186,17 @@ WRAP:
 		}
 	}
 
+	return t.In(origLocation)
+}
+
+// dayStart returns the first moment of the given day: midnight, or the end of
+// the hour skipped on days the clock skips midnight.
+func dayStart(year int, month time.Month, day int, loc *time.Location) time.Time {
+	t := time.Date(year, month, day, 0, 0, 0, 0, loc)
+	if t.Hour() > 12 {
+		// Setting a skipped midnight may give the hour before it.
+		t = t.Add(time.Duration(24-t.Hour()) * time.Hour)
+	}
 	return t
 }
 
@@ -150,8 +204,8 @@ WRAP:
 // restrictions are satisfied by the given time.
 func dayMatches(s *SpecSchedule, t time.Time) bool {
 	var (
-		domMatch bool = 1<<uint(t.Day())&s.Dom > 0
-		dowMatch bool = 1<<uint(t.Weekday())&s.Dow > 0
+		domMatch bool = 1<<uint(t.Day())&s.Dom > 0 || quartzDomMatches(s, t)
+		dowMatch bool = 1<<uint(t.Weekday())&s.Dow > 0 || quartzDowMatches(s, t)
 	)
 
 	if s.Dom&starBit > 0 || s.Dow&starBit > 0 {
@@ -159,3 +213,55 @@ func dayMatches(s *SpecSchedule, t time.Time) bool {
 	}
 	return domMatch || dowMatch
 }
+
+// quartzDomMatches returns true if the day of the given time is the last of
+// its month, or the weekday nearest a day, as the schedule asks.
+func quartzDomMatches(s *SpecSchedule, t time.Time) bool {
+	if !s.LastDom && s.NearestWeekday == 0 {
+		return false
+	}
+	last := daysIn(t.Year(), t.Month())
+	if s.LastDom && t.Day() == last {
+		return true
+	}
+	for day := 1; day <= last; day++ {
+		if 1<<uint(day)&s.NearestWeekday > 0 && nearestWeekday(t.Year(), t.Month(), day) == t.Day() {
+			return true
+		}
+	}
+	return s.NearestWeekday&1 > 0 && nearestWeekday(t.Year(), t.Month(), last) == t.Day()
+}
+
+// quartzDowMatches returns true if the day of the given time is the last or
+// the k-th of its day of week in its month, as the schedule asks.
+func quartzDowMatches(s *SpecSchedule, t time.Time) bool {
+	weekday := uint64(1) << uint(t.Weekday())
+	if s.LastDow&weekday > 0 && t.Day()+7 > daysIn(t.Year(), t.Month()) {
+		return true
+	}
+	return s.NthDow[(t.Day()-1)/7]&weekday > 0
+}
+
+// daysIn returns the number of days in the given month.
+func daysIn(year int, month time.Month) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+// nearestWeekday returns the weekday nearest the given day, without leaving
+// its month: the Friday before a Saturday and the Monday after a Sunday,
+// unless that is in another month.
+func nearestWeekday(year int, month time.Month, day int) int {
+	switch time.Date(year, month, day, 0, 0, 0, 0, time.UTC).Weekday() {
+	case time.Saturday:
+		if day == 1 {
+			return day + 2
+		}
+		return day - 1
+	case time.Sunday:
+		if day == daysIn(year, month) {
+			return day - 2
+		}
+		return day + 1
+	}
+	return day
+}
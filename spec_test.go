@@ -55,6 +55,31 @@ func TestActivation(t *testing.T) {
 		{"Mon Jul 9 00:00 2012", "0 * * 1,15 * *", false},
 		{"Sun Jul 15 00:00 2012", "0 * * 1,15 * *", true},
 		{"Sun Jul 15 00:00 2012", "0 * * */2 * Sun", true},
+
+		// Last day of the month.
+		{"Tue Jul 31 00:00 2012", "0 0 0 L * ?", true},
+		{"Mon Jul 30 00:00 2012", "0 0 0 L * ?", false},
+		{"Wed Feb 29 00:00 2012", "0 0 0 L * ?", true},
+		{"Sun Jul 1 00:00 2012", "0 0 0 1,L * ?", true},
+		{"Tue Jul 31 00:00 2012", "0 0 0 1,L * ?", true},
+
+		// Weekday nearest a day of the month, without leaving the month.
+		{"Mon Jul 16 00:00 2012", "0 0 0 15W * ?", true},
+		{"Sun Jul 15 00:00 2012", "0 0 0 15W * ?", false},
+		{"Fri Sep 14 00:00 2012", "0 0 0 15W * ?", true},
+		{"Mon Sep 3 00:00 2012", "0 0 0 1W * ?", true},
+		{"Fri Mar 29 00:00 2013", "0 0 0 31W * ?", true},
+		{"Fri Jun 29 00:00 2012", "0 0 0 LW * ?", true},
+		{"Sat Jun 30 00:00 2012", "0 0 0 LW * ?", false},
+
+		// The k-th and the last day of week of the month.
+		{"Tue Jul 10 00:00 2012", "0 0 0 ? * 2#2", true},
+		{"Tue Jul 10 00:00 2012", "0 0 0 ? * Tue#2", true},
+		{"Tue Jul 3 00:00 2012", "0 0 0 ? * 2#2", false},
+		{"Tue Jul 17 00:00 2012", "0 0 0 ? * 2#2", false},
+		{"Fri Jul 27 00:00 2012", "0 0 0 ? * 5L", true},
+		{"Fri Jul 27 00:00 2012", "0 0 0 ? * friL", true},
+		{"Fri Jul 20 00:00 2012", "0 0 0 ? * 5L", false},
 	}
 	Convey("Test time should be equal.", t, func() {
 		for _, test := range tests {
@@ -117,6 +142,20 @@ func TestNext(t *testing.T) {
 		{"2012-11-04T00:00:00-0400", "0 30 2 04 Nov ?", "2012-11-04T02:30:00-0500"},
 		{"2012-11-04T01:45:00-0400", "0 30 1 04 Nov ?", "2012-11-04T01:30:00-0500"},
 
+		// Quartz day forms
+		{"Mon Jul 9 23:35 2012", "0 0 0 L * ?", "Tue Jul 31 00:00 2012"},
+		{"Mon Jul 9 23:35 2012", "0 0 0 ? * 5L", "Fri Jul 27 00:00 2012"},
+		{"Mon Jul 9 23:35 2012", "0 0 0 ? * 2#2", "Tue Jul 10 00:00 2012"},
+		{"Mon Jul 9 23:35 2012", "0 0 0 ? * 5#5", "Fri Aug 31 00:00 2012"},
+
+		// Years
+		{"Mon Jul 9 23:35 2012", "0 0 0 1 1 ? 2027-2030", "Fri Jan 1 00:00 2027"},
+		{"Fri Jan 1 00:00 2027", "0 0 0 1 1 ? 2027-2030", "Sat Jan 1 00:00 2028"},
+		{"Tue Jan 1 00:00 2030", "0 0 0 1 1 ? 2027-2030", ""},
+		{"Mon Jul 9 23:35 2012", "0 0 0 29 Feb ? 2040", "Wed Feb 29 00:00 2040"},
+		{"Mon Jul 9 23:35 2012", "0 0 0 1 Jan ? 2012/10,2016", "Fri Jan 1 00:00 2016"},
+		{"Mon Jul 9 23:35 2012", "0 0 0 30 Jun ? 2012", ""},
+
 		// Unsatisfiable
 		{"Mon Jul 9 23:35 2012", "0 0 0 30 Feb ?", ""},
 		{"Mon Jul 9 23:35 2012", "0 0 0 31 Apr ?", ""},
@@ -130,6 +169,46 @@ func TestNext(t *testing.T) {
 	})
 }
 
+func TestLocation(t *testing.T) {
+	runs := []struct {
+		time, spec string
+		expected   string
+	}{
+		// The spec is read on the clock of its zone, the answer given in the
+		// zone of the time asked about.
+		{"2026-10-17T00:00:00Z", "CRON_TZ=Asia/Shanghai 0 0 9 * * *", "2026-10-17T01:00:00Z"},
+		{"2026-10-17T02:00:00Z", "TZ=Asia/Shanghai 0 0 9 * * *", "2026-10-18T01:00:00Z"},
+		{"2026-10-17T00:00:00Z", "CRON_TZ=Asia/Shanghai @daily", "2026-10-17T16:00:00Z"},
+
+		// New York springs forward from 2:00 EST to 3:00 EDT (07:00Z): 2:30
+		// never comes, and the hour after 1:00 is 3:00.
+		{"2026-03-08T05:00:00Z", "CRON_TZ=America/New_York 0 30 2 * * *", "2026-03-09T06:30:00Z"},
+		{"2026-03-08T06:00:00Z", "CRON_TZ=America/New_York 0 0 * * * *", "2026-03-08T07:00:00Z"},
+
+		// It falls back from 2:00 EDT to 1:00 EST (06:00Z): 1:30 comes twice.
+		{"2026-11-01T05:00:00Z", "CRON_TZ=America/New_York 0 30 1 * * *", "2026-11-01T05:30:00Z"},
+		{"2026-11-01T05:30:00Z", "CRON_TZ=America/New_York 0 30 1 * * *", "2026-11-01T06:30:00Z"},
+		{"2026-11-01T06:30:00Z", "CRON_TZ=America/New_York 0 30 1 * * *", "2026-11-02T06:30:00Z"},
+
+		// Sao Paulo sprang forward at midnight, so that Nov 4 2018 had none,
+		// and fell back at midnight, so that Feb 16 2019 had 23:00 twice.
+		{"2018-11-03T15:00:00Z", "CRON_TZ=America/Sao_Paulo @daily", "2018-11-05T02:00:00Z"},
+		{"2018-11-03T15:00:00Z", "CRON_TZ=America/Sao_Paulo 0 0 12 4 Nov ?", "2018-11-04T14:00:00Z"},
+		{"2018-11-03T15:00:00Z", "CRON_TZ=America/Sao_Paulo 0 0 0 5 Nov ?", "2018-11-05T02:00:00Z"},
+		{"2019-02-16T12:00:00Z", "CRON_TZ=America/Sao_Paulo 0 0 23 * * *", "2019-02-17T01:00:00Z"},
+		{"2019-02-17T01:00:00Z", "CRON_TZ=America/Sao_Paulo 0 0 23 * * *", "2019-02-17T02:00:00Z"},
+		{"2019-02-16T12:00:00Z", "CRON_TZ=America/Sao_Paulo @daily", "2019-02-17T03:00:00Z"},
+	}
+	Convey("Specs with a time zone are read on its clock.", t, func() {
+		for _, c := range runs {
+			from, _ := time.Parse(time.RFC3339, c.time)
+			expected, _ := time.Parse(time.RFC3339, c.expected)
+			actual := Parse(c.spec).Next(from)
+			So(actual.Format(time.RFC3339), ShouldEqual, expected.Format(time.RFC3339))
+		}
+	})
+}
+
 func getTime(value string) time.Time {
 	if value == "" {
 		return time.Time{}